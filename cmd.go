@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"telegram-env-watcher/ql"
+	"telegram-env-watcher/utils"
+)
+
+// runCommand 执行维护子命令，不启动 Telegram 监听
+//
+//	history [NAME]                         查看变量写入历史
+//	rollback NAME [--to N] [--instance X]  恢复到 N 次更新之前的值（默认 1）
+func runCommand(ctx context.Context, cfg *utils.Config, args []string) error {
+	switch args[0] {
+	case "history":
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		return printHistory(name)
+	case "rollback":
+		return rollback(ctx, cfg, args[1:])
+	default:
+		return fmt.Errorf("未知命令: %s（可用: history, rollback）", args[0])
+	}
+}
+
+func printHistory(name string) error {
+	entries, err := ql.History(name)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("📭 没有变量历史记录")
+		return nil
+	}
+	for i, e := range entries {
+		old := e.Old
+		if e.Created {
+			old = "（新增）"
+		}
+		name := e.Name
+		if e.Rollback {
+			name += " ↩️ 回滚"
+		}
+		fmt.Printf("[%d] #%d %s [%s] %s\n    来源: %s\n    旧值: %s\n    新值: %s\n",
+			i+1, e.Seq, time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"), e.Instance, name,
+			e.Source, old, e.New)
+	}
+	return nil
+}
+
+func rollback(ctx context.Context, cfg *utils.Config, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	to := fs.Int("to", 1, "恢复到第 N 次更新之前的值")
+	instance := fs.String("instance", "", "青龙实例名称，默认为最近一次写入的实例")
+
+	// 允许变量名写在参数前面：rollback NAME --to 2
+	var name string
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if name == "" && fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	if name == "" {
+		return fmt.Errorf("用法: rollback NAME [--to N] [--instance X]")
+	}
+
+	router, err := ql.NewRouter(cfg, ql.WithDebug(cfg.Debug))
+	if err != nil {
+		return err
+	}
+	entry, err := router.Rollback(ctx, name, *instance, *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ [%s] 变量 %s 已回滚（记录 #%d，来源: %s）\n", entry.Instance, name, entry.Seq, entry.Source)
+	return nil
+}
@@ -32,6 +32,23 @@ func main() {
 		log.Fatalf("❌ 配置文件读取失败: %v", err)
 	}
 
+	// 维护子命令：history / rollback
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), cfg, os.Args[1:]); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
+
+	router, err := ql.NewRouter(cfg, ql.WithDebug(cfg.Debug))
+	if err != nil {
+		log.Fatalf("❌ 青龙配置错误: %v", err)
+	}
+	qlc := router.Primary()
+	if err := watcher.ValidateURLRules(cfg.URLRules); err != nil {
+		log.Fatalf("❌ URL 规则配置错误: %v", err)
+	}
+
 	disp := tg.NewUpdateDispatcher()
 	gaps := updates.New(updates.Config{
 		Handler: &disp,
@@ -68,8 +85,11 @@ func main() {
 				log.Printf("❌ 解析频道 @%s 失败: %v", ch.Username, err)
 				continue
 			}
+			if err := targets.AddChannel(ch, inputCh); err != nil {
+				log.Printf("❌ 频道 @%s 配置无效: %v", ch.Username, err)
+				continue
+			}
 			log.Printf("📢 监听频道: %s\n简介: %s\n", title, about)
-			targets.Channels = append(targets.Channels, inputCh)
 		}
 		for _, us := range cfg.Listen.Users {
 			_, inputUser, title, about, err := utils.ResolveTarget(ctx, client, us.Username)
@@ -77,8 +97,18 @@ func main() {
 				log.Printf("❌ 解析用户 @%s 失败: %v", us.Username, err)
 				continue
 			}
+			if err := targets.AddUser(us, inputUser); err != nil {
+				log.Printf("❌ 用户 @%s 配置无效: %v", us.Username, err)
+				continue
+			}
 			log.Printf("💬 监听用户: %s\n简介: %s\n", title, about)
-			targets.Users = append(targets.Users, inputUser)
+		}
+
+		if err := targets.SetFilter(cfg.Allow, cfg.Deny); err != nil {
+			log.Fatalf("❌ 变量允许/禁止列表配置错误: %v", err)
+		}
+		if err := targets.SetValueRules(cfg.ValueRules); err != nil {
+			log.Fatalf("❌ 变量值规则配置错误: %v", err)
 		}
 
 		if len(targets.Channels) == 0 && len(targets.Users) == 0 {
@@ -86,7 +116,7 @@ func main() {
 		}
 
 		// 注册回调处理器
-		watcher.RegisterHandlers(&disp, client, cfg, &targets)
+		watcher.RegisterHandlers(&disp, client, router, cfg, &targets)
 
 		user, err := client.Self(ctx)
 		if err != nil {
@@ -95,14 +125,14 @@ func main() {
 
 		log.Printf("🚀 Telegram 已登录，用户ID: %d\n", user.ID)
 		// ✅ 启动时立即 Flush 上次未发出的通知
-		//if err := ql.FlushNotifyBuffer(cfg); err != nil {
+		//if err := qlc.FlushNotifyBuffer(ctx); err != nil {
 		//	log.Printf("⚠️ 启动时通知缓存发送失败: %v", err)
 		//}
-		ql.PushStatsOnce(cfg)
+		qlc.PushStatsOnce(ctx)
 
 		// ✅ 启动定时器，等待整点执行
-		//ql.StartNotifyScheduler(cfg)
-		ql.StartStatsScheduler(cfg)
+		//qlc.StartNotifyScheduler(ctx)
+		qlc.StartStatsScheduler(ctx)
 		return gaps.Run(ctx, client.API(), user.ID, updates.AuthOptions{})
 	})
 
@@ -0,0 +1,99 @@
+package ql
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"telegram-env-watcher/utils"
+)
+
+// defaultAliases 未配置 aliases 时沿用的规则：lzkj 的变量同时写入 lzkj_v2 分支
+var defaultAliases = []utils.AliasConfig{{Match: "lzkj", Replace: []string{"lzkj_v2"}}}
+
+// aliasRule 编译后的变量别名规则
+type aliasRule struct {
+	literal string
+	re      *regexp.Regexp
+	replace []string
+	rename  bool
+}
+
+// compileAliases 未配置（nil）时使用默认规则，配置为空列表则不启用别名
+func compileAliases(cfgs []utils.AliasConfig) []aliasRule {
+	if cfgs == nil {
+		cfgs = defaultAliases
+	}
+	var rules []aliasRule
+	for _, a := range cfgs {
+		if a.Match == "" || len(a.Replace) == 0 {
+			log.Printf("⚠️ 变量别名规则缺少 match 或 replace，已忽略: %+v", a)
+			continue
+		}
+		r := aliasRule{literal: a.Match, replace: a.Replace, rename: a.Rename}
+		if a.Regex {
+			re, err := regexp.Compile(a.Match)
+			if err != nil {
+				log.Printf("⚠️ 变量别名规则正则无效 %s: %v", a.Match, err)
+				continue
+			}
+			r.re = re
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// apply 替换变量名中第一处匹配，未匹配时返回 false
+func (r aliasRule) apply(name, repl string) (string, bool) {
+	if r.re == nil {
+		if !strings.Contains(name, r.literal) {
+			return "", false
+		}
+		return strings.Replace(name, r.literal, repl, 1), true
+	}
+	loc := r.re.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return "", false
+	}
+	dst := r.re.ExpandString(nil, repl, name, loc)
+	return name[:loc[0]] + string(dst) + name[loc[1]:], true
+}
+
+// EnvNames 返回变量实际要写入的名称：原名加上所有命中规则的别名，
+// 命中 rename 规则时不再写入原名
+func (c *Client) EnvNames(name string) []string {
+	keep := true
+	var aliases []string
+	for _, r := range c.aliases {
+		for _, repl := range r.replace {
+			alias, ok := r.apply(name, repl)
+			if !ok {
+				break
+			}
+			if r.rename {
+				keep = false
+			}
+			if alias != "" && alias != name {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+
+	var names []string
+	if keep {
+		names = append(names, name)
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, n := range append(names, aliases...) {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	if len(out) == 0 {
+		return []string{name}
+	}
+	return out
+}
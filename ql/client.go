@@ -0,0 +1,262 @@
+package ql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"telegram-env-watcher/utils"
+)
+
+const (
+	// 未配置 timeout 时的单次请求超时
+	defaultTimeout = 15 * time.Second
+	// 未配置 retries 时的最大重试次数
+	defaultRetries = 3
+)
+
+// Client 青龙开放 API 客户端，所有请求都受调用方 ctx 控制
+type Client struct {
+	cfg     utils.QLConfig
+	debug   bool
+	timeout time.Duration
+	retries int
+	rt      http.RoundTripper
+	http    *http.Client
+
+	duplicates DuplicatePolicy
+	modes      []envModeRule
+	aliases    []aliasRule
+
+	notifier *Client // 本实例未配置通知脚本时代为发送通知的主实例
+
+	trackInterval time.Duration
+	trackTimeout  time.Duration // <= 0 表示不跟踪运行结果
+	failPatterns  []string
+
+	cooldown      time.Duration
+	maxConcurrent int
+	ifRunning     RunningPolicy
+
+	runMu   sync.Mutex
+	lastRun map[int]time.Time // 脚本上次由本程序触发的时间
+	queued  map[int]bool      // 等待当前运行结束的脚本
+	stopped map[int]time.Time // 脚本上次被重启策略停止的时间
+	active  int               // 已触发且尚未结束的脚本数
+}
+
+// Option 自定义 Client 的可选项
+type Option func(*Client)
+
+// WithDebug 打印请求细节
+func WithDebug(debug bool) Option {
+	return func(c *Client) { c.debug = debug }
+}
+
+// WithTimeout 覆盖配置中的单次请求超时
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithRetries 覆盖配置中的最大重试次数，0 表示不重试
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithTransport 注入自定义 RoundTripper（代理、测试桩等）
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.rt = rt }
+}
+
+// NewClient 根据 ql 配置段创建客户端
+func NewClient(cfg utils.QLConfig, opts ...Option) *Client {
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Name == "" {
+		if u, err := url.Parse(cfg.BaseURL); err == nil && u.Host != "" {
+			cfg.Name = u.Host
+		} else {
+			cfg.Name = cfg.BaseURL
+		}
+	}
+	c := &Client{
+		cfg:     cfg,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+		retries: cfg.Retries,
+	}
+	if c.retries == 0 {
+		c.retries = defaultRetries
+	}
+	policy, err := ParseDuplicatePolicy(cfg.DuplicatePolicy)
+	if err != nil {
+		log.Printf("⚠️ %v，使用默认策略", err)
+	}
+	c.duplicates = policy
+	c.modes = compileEnvModes(cfg.EnvModes)
+	c.aliases = compileAliases(cfg.Aliases)
+
+	c.trackInterval = time.Duration(cfg.TrackInterval) * time.Second
+	if c.trackInterval <= 0 {
+		c.trackInterval = defaultTrackInterval
+	}
+	switch {
+	case cfg.TrackTimeout < 0:
+		c.trackTimeout = 0
+	case cfg.TrackTimeout == 0:
+		c.trackTimeout = defaultTrackTimeout
+	default:
+		c.trackTimeout = time.Duration(cfg.TrackTimeout) * time.Second
+	}
+	c.failPatterns = cfg.FailPatterns
+	if len(c.failPatterns) == 0 {
+		c.failPatterns = defaultFailPatterns
+	}
+
+	c.cooldown = time.Duration(cfg.Cooldown) * time.Second
+	c.maxConcurrent = cfg.MaxConcurrent
+	if c.maxConcurrent > 0 && c.trackTimeout <= 0 {
+		// 并发名额在跟踪到运行结束时归还，不跟踪时无法统计正在运行的脚本
+		log.Printf("⚠️ [%s] max_concurrent 需要跟踪运行结果，track_timeout 为 -1 时不生效", c.Name())
+		c.maxConcurrent = 0
+	}
+	if c.ifRunning, err = parseRunningPolicy(cfg.IfRunning); err != nil {
+		log.Printf("⚠️ %v，不检查运行状态", err)
+	}
+	c.lastRun = make(map[int]time.Time)
+	c.queued = make(map[int]bool)
+	c.stopped = make(map[int]time.Time)
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	if c.retries < 0 {
+		c.retries = 0
+	}
+	c.http = &http.Client{Timeout: c.timeout, Transport: c.rt}
+	return c
+}
+
+// Name 返回实例名称
+func (c *Client) Name() string {
+	return c.cfg.Name
+}
+
+// Token 返回缓存的青龙 token，必要时自动刷新
+func (c *Client) Token(ctx context.Context) (string, error) {
+	return tokens.get(c.tokenKey(), func() (cachedToken, error) {
+		return c.fetchToken(ctx)
+	})
+}
+
+// do 发送带鉴权的请求并返回响应体，状态码非 2xx 时返回 *APIError
+// 幂等请求遇到网络错误或 5xx 时按指数退避重试，非幂等请求只在确定未送达时重试
+func (c *Client) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	idempotent := isIdempotent(method, path)
+	var result []byte
+	op := func() error {
+		b, err := c.doOnce(ctx, method, path, body)
+		if err == nil {
+			result = b
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err, idempotent) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, wait time.Duration) {
+		log.Printf("⚠️ 青龙请求失败，%s 后重试: %v", wait.Round(time.Millisecond), err)
+	}
+	b := backoff.WithContext(backoff.WithMaxRetries(backoff.NewExponentialBackOff(
+		backoff.WithInitialInterval(500*time.Millisecond),
+		backoff.WithMaxInterval(10*time.Second),
+	), uint64(c.retries)), ctx)
+	if err := backoff.RetryNotify(op, b, notify); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// doOnce 发送单次请求，遇到 401 时刷新 token 并立即重发一次
+func (c *Client) doOnce(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	fullURL := c.cfg.BaseURL + path
+	for attempt := 0; ; attempt++ {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, fullURL, reader)
+		if err != nil {
+			return nil, backoff.Permanent(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+		}
+
+		if c.debug {
+			log.Printf("🔗 请求地址: %s\n", fullURL)
+			log.Printf("📦 请求方法: %s\n", method)
+			log.Printf("🔐 Authorization: Bearer %s\n", token)
+			if body != nil {
+				log.Printf("📝 请求 Body: %s\n", string(body))
+			}
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			log.Println("🔑 token 已失效，重新获取后重试")
+			tokens.invalidate(c.tokenKey(), token)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return nil, statusError(method, path, resp.StatusCode, respBody)
+		}
+		return respBody, nil
+	}
+}
+
+// decode 解析青龙标准响应 {"code":200,"data":...}，code 非 200 时按状态码分类
+func decode(method, path string, body []byte, out any) error {
+	var envelope struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return decodeError(method, path, body, err)
+	}
+	if envelope.Code != 0 && envelope.Code != 200 {
+		return statusError(method, path, envelope.Code, body)
+	}
+	if out == nil || len(envelope.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return decodeError(method, path, body, err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package ql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"telegram-env-watcher/utils"
+)
+
+// stubTransport 登录请求返回 token，其余请求返回固定状态码并按“方法 路径”计数
+// bodies 按“方法 路径”覆盖响应体，delay 模拟较慢的青龙响应
+type stubTransport struct {
+	status int
+	bodies map[string]string
+	delay  time.Duration
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Path
+	body := `{"code":200,"data":[]}`
+	status := s.status
+	if req.URL.Path == "/open/auth/token" {
+		body = `{"code":200,"data":{"token":"t","expiration":4102444800}}`
+		status = http.StatusOK
+	} else {
+		s.mu.Lock()
+		s.calls[key]++
+		s.mu.Unlock()
+		if b, ok := s.bodies[key]; ok {
+			body = b
+		}
+		time.Sleep(s.delay)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func (s *stubTransport) count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[key]
+}
+
+func TestClientRetry(t *testing.T) {
+	t.Chdir(t.TempDir()) // token 缓存写入当前目录
+	tokens = &tokenManager{}
+
+	const retries = 1
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		calls  int
+	}{
+		{"定时任务运行遇到 5xx 不重试", "PUT", "/open/crons/run", http.StatusBadGateway, 1},
+		{"脚本运行遇到 5xx 不重试", "PUT", "/open/scripts/run", http.StatusBadGateway, 1},
+		{"定时任务运行被限流时重试", "PUT", "/open/crons/run", http.StatusTooManyRequests, 1 + retries},
+		{"幂等请求遇到 5xx 重试", "GET", "/open/crons", http.StatusBadGateway, 1 + retries},
+		{"新增变量遇到 5xx 不重试", "POST", "/open/envs", http.StatusBadGateway, 1},
+		{"4xx 不重试", "PUT", "/open/envs", http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &stubTransport{status: tt.status, calls: make(map[string]int)}
+			c := NewClient(utils.QLConfig{BaseURL: "http://ql.test", ClientID: "id"},
+				WithTransport(rt), WithRetries(retries))
+			if _, err := c.do(t.Context(), tt.method, tt.path, []byte("[1]")); err == nil {
+				t.Fatal("期望请求失败")
+			}
+			if got := rt.count(tt.method + " " + tt.path); got != tt.calls {
+				t.Errorf("请求次数 = %d，期望 %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+// fakeQL 在内存中模拟青龙的环境变量接口
+type fakeQL struct {
+	mu     sync.Mutex
+	envs   []Env
+	nextID int64
+}
+
+func newFakeQL(seed ...Env) *fakeQL {
+	f := &fakeQL{nextID: 100}
+	f.envs = append(f.envs, seed...)
+	return f
+}
+
+func (f *fakeQL) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var in []byte
+	if req.Body != nil {
+		in, _ = io.ReadAll(req.Body)
+	}
+	status, data := http.StatusOK, any(nil)
+	switch req.Method + " " + req.URL.Path {
+	case "GET /open/auth/token":
+		data = map[string]any{"token": "t", "expiration": 4102444800}
+	case "GET /open/envs":
+		q := req.URL.Query().Get("searchValue")
+		var out []Env
+		for _, e := range f.envs {
+			if strings.Contains(e.Name, q) || strings.Contains(e.Value, q) {
+				out = append(out, e)
+			}
+		}
+		data = out
+	case "POST /open/envs":
+		var envs []Env
+		json.Unmarshal(in, &envs)
+		for i := range envs {
+			id := f.nextID
+			f.nextID++
+			envs[i].ID = &id
+			f.envs = append(f.envs, envs[i])
+		}
+		data = envs
+	case "PUT /open/envs":
+		var e Env
+		json.Unmarshal(in, &e)
+		if i := f.index(*e.ID); i >= 0 {
+			f.envs[i].Value = e.Value
+		} else {
+			status = http.StatusNotFound
+		}
+	case "PUT /open/envs/disable":
+		var ids []int64
+		json.Unmarshal(in, &ids)
+		for _, id := range ids {
+			if i := f.index(id); i >= 0 {
+				f.envs[i].Status = 1
+			}
+		}
+	default:
+		status = http.StatusNotFound
+	}
+	body, _ := json.Marshal(map[string]any{"code": 200, "data": data})
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func (f *fakeQL) index(id int64) int {
+	for i, e := range f.envs {
+		if *e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// delete 模拟在青龙面板中手动删除变量
+func (f *fakeQL) delete(id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := f.index(id); i >= 0 {
+		f.envs = append(f.envs[:i], f.envs[i+1:]...)
+	}
+}
+
+// state 按 ID 顺序返回 “值/状态” 列表，便于比较
+func (f *fakeQL) state() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, e := range f.envs {
+		out = append(out, fmt.Sprintf("%d:%s/%d", *e.ID, e.Value, e.Status))
+	}
+	return out
+}
+
+func envID(id int64) *int64 { return &id }
@@ -0,0 +1,226 @@
+package ql
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 青龙定时任务状态（/open/crons/:id 返回的 status），1 空闲，2 禁用
+const (
+	cronRunning = 0
+	cronQueued  = 0.5
+)
+
+const (
+	defaultTrackInterval = 10 * time.Second
+	defaultTrackTimeout  = 30 * time.Minute
+	// 失败通知中附带的日志行数
+	logTailLines = 15
+	// 统计中最多保留的失败记录数
+	maxFailedRuns = 50
+)
+
+// 日志中出现这些内容时视为运行失败，可通过 fail_patterns 覆盖
+var defaultFailPatterns = []string{"Error:", "Traceback", "Command failed", "执行失败"}
+
+// 运行结果
+const (
+	RunSuccess = "success"
+	RunFailed  = "failed"
+	RunTimeout = "timeout"
+	RunStopped = "stopped" // 被 if_running=restart 停止
+)
+
+// CronRun 一次脚本运行的实际结果
+type CronRun struct {
+	Instance string `json:"instance"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Duration int64  `json:"duration"` // 秒
+	LogTail  string `json:"log_tail,omitempty"`
+	Time     int64  `json:"time"` // 触发时间，Unix 时间戳
+}
+
+func (r CronRun) String() string {
+	state := "失败"
+	if r.State == RunTimeout {
+		state = "超时"
+	}
+	s := fmt.Sprintf("[%s] %s (ID: %d) %s，耗时 %ds", r.Instance, r.Name, r.ID, state, r.Duration)
+	if r.LogTail != "" {
+		s += "\n" + r.LogTail
+	}
+	return s
+}
+
+type cronDetail struct {
+	ID                int     `json:"id"`
+	Name              string  `json:"name"`
+	Status            float64 `json:"status"`
+	LastRunningTime   int64   `json:"last_running_time"`   // 上次运行耗时（秒）
+	LastExecutionTime int64   `json:"last_execution_time"` // 上次开始运行时间
+}
+
+func (c *Client) cronDetail(ctx context.Context, id int) (*cronDetail, error) {
+	path := fmt.Sprintf("/open/crons/%d", id)
+	body, err := c.do(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var d cronDetail
+	if err := decode("GET", path, body, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (c *Client) cronLog(ctx context.Context, id int) (string, error) {
+	path := fmt.Sprintf("/open/crons/%d/log", id)
+	body, err := c.do(ctx, "GET", path, nil)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	if err := decode("GET", path, body, &text); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// cronBaselines 记录触发前每个脚本的上次开始时间，用于识别本次运行
+// 查询失败的脚本不记录，退化为与触发时间比较
+func (c *Client) cronBaselines(ctx context.Context, scripts []ScriptInfo) map[int]int64 {
+	baselines := make(map[int]int64, len(scripts))
+	for _, s := range scripts {
+		if d, err := c.cronDetail(ctx, s.ID); err == nil {
+			baselines[s.ID] = d.LastExecutionTime
+		}
+	}
+	return baselines
+}
+
+// trackRuns 等待每个脚本运行结束，记录实际结果并推送失败通知
+func (c *Client) trackRuns(ctx context.Context, scripts []ScriptInfo, baselines map[int]int64, started time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, c.trackTimeout)
+	defer cancel()
+
+	results := make(chan CronRun, len(scripts))
+	for _, s := range scripts {
+		go func(s ScriptInfo) {
+			baseline, ok := baselines[s.ID]
+			if !ok {
+				baseline = started.Unix() - 1
+			}
+			results <- c.waitCron(ctx, s, baseline, started)
+		}(s)
+	}
+
+	var failed []CronRun
+	for range scripts {
+		run := <-results
+		c.release([]ScriptInfo{{ID: run.ID}}, false)
+		if run.State == RunStopped {
+			// 已由重启后的运行接替，不计入成功或失败
+			log.Printf("⏹ [%s] 脚本 %s 已被停止并重新触发，耗时 %ds", run.Instance, run.Name, run.Duration)
+			updateDailyStats(func(stats *DailyStats) {
+				stats.Stopped++
+			})
+			continue
+		}
+		if run.State == RunSuccess {
+			log.Printf("✅ [%s] 脚本 %s 运行成功，耗时 %ds", run.Instance, run.Name, run.Duration)
+		} else {
+			log.Printf("❌ [%s] 脚本 %s 运行%s，耗时 %ds", run.Instance, run.Name, run.State, run.Duration)
+			failed = append(failed, run)
+		}
+		updateDailyStats(func(stats *DailyStats) {
+			if run.State == RunSuccess {
+				stats.Success++
+				return
+			}
+			stats.Fail++
+			stats.FailedRuns = append(stats.FailedRuns, run)
+			if n := len(stats.FailedRuns); n > maxFailedRuns {
+				stats.FailedRuns = stats.FailedRuns[n-maxFailedRuns:]
+			}
+		})
+	}
+
+	if len(failed) > 0 {
+		var lines []string
+		for _, r := range failed {
+			lines = append(lines, r.String())
+		}
+		// 使用独立的超时，跟踪超时后仍能发出通知
+		notifyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout*2)
+		defer cancel()
+		if err := c.SendNotifyNow(notifyCtx, "❌ 脚本运行失败", strings.Join(lines, "\n\n")); err != nil {
+			log.Printf("❌ 推送脚本失败通知失败: %v", err)
+		}
+	}
+}
+
+// waitCron 轮询脚本状态直到本次运行结束，然后读取日志判断结果
+func (c *Client) waitCron(ctx context.Context, s ScriptInfo, baseline int64, started time.Time) CronRun {
+	run := CronRun{Instance: c.Name(), ID: s.ID, Name: s.Name, State: RunTimeout, Time: started.Unix()}
+	ticker := time.NewTicker(c.trackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			run.Duration = int64(time.Since(started).Seconds())
+			return run
+		case <-ticker.C:
+		}
+
+		d, err := c.cronDetail(ctx, s.ID)
+		if err != nil {
+			if c.debug {
+				log.Printf("⚠️ 查询脚本状态失败 (ID: %d): %v", s.ID, err)
+			}
+			continue
+		}
+		// 仍在排队/运行，或尚未开始本次运行
+		if d.Status == cronRunning || d.Status == cronQueued || d.LastExecutionTime <= baseline {
+			continue
+		}
+
+		run.Duration = d.LastRunningTime
+		if c.stoppedSince(s.ID, started) {
+			run.State = RunStopped
+			return run
+		}
+		logText, err := c.cronLog(ctx, s.ID)
+		if err != nil {
+			log.Printf("⚠️ 读取脚本日志失败 (ID: %d): %v", s.ID, err)
+		}
+		run.LogTail = tailLines(logText, logTailLines)
+		run.State = RunSuccess
+		if c.logFailed(logText) {
+			run.State = RunFailed
+		}
+		return run
+	}
+}
+
+func (c *Client) logFailed(text string) bool {
+	for _, p := range c.failPatterns {
+		if strings.Contains(text, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func tailLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,197 @@
+package ql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DuplicatePolicy 青龙中存在多个同名变量时的处理方式
+type DuplicatePolicy string
+
+const (
+	DuplicateFirst   DuplicatePolicy = "first"   // 只更新第一个（默认）
+	DuplicateAll     DuplicatePolicy = "all"     // 全部更新
+	DuplicateEnabled DuplicatePolicy = "enabled" // 只更新启用的，没有启用的则新增
+	DuplicateCreate  DuplicatePolicy = "create"  // 始终新增一个
+)
+
+// ParseDuplicatePolicy 解析配置中的策略名，空值返回默认策略
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return DuplicateFirst, nil
+	case DuplicateFirst, DuplicateAll, DuplicateEnabled, DuplicateCreate:
+		return p, nil
+	default:
+		return DuplicateFirst, fmt.Errorf("未知的重复变量策略: %s", s)
+	}
+}
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case DuplicateAll:
+		return "更新全部"
+	case DuplicateEnabled:
+		return "仅更新启用项"
+	case DuplicateCreate:
+		return "新增"
+	default:
+		return "更新第一个"
+	}
+}
+
+// EnvResult 单个变量名的写入结果
+type EnvResult struct {
+	Name    string
+	Policy  DuplicatePolicy
+	Mode    EnvMode
+	Matched int  // 青龙中同名变量数量
+	Updated int  // 更新的条目数
+	Created bool // 是否新增了条目
+}
+
+func (r EnvResult) String() string {
+	s := fmt.Sprintf("%s（策略: %s，同名 %d 个", r.Name, r.Policy, r.Matched)
+	if r.Mode.Mode != MergeReplace {
+		s += "，合并: " + r.Mode.String()
+	}
+	if r.Updated > 0 {
+		s += fmt.Sprintf("，更新 %d 个", r.Updated)
+	}
+	if r.Created {
+		s += "，新增 1 个"
+	}
+	return s + "）"
+}
+
+// UpdateEnvNames 按重复变量策略将同一个值依次写入（不存在则新增）names 中的每个青龙环境变量
+// names 通常来自 EnvNames，调用方需先按允许/禁止列表筛掉被禁止的别名
+// 配置了合并规则的变量会与青龙中的当前值合并，而不是直接覆盖
+// 每次写入都会记录到本地历史，可通过 Rollback 恢复
+func (c *Client) UpdateEnvNames(ctx context.Context, names []string, value string, src Source) ([]EnvResult, error) {
+	// 定义一个内部函数，单次更新逻辑
+	updateSingle := func(name, value string) (EnvResult, error) {
+		mode := c.modeFor(name)
+		res := EnvResult{Name: name, Policy: c.duplicates, Mode: mode}
+		existing, err := c.searchEnvs(ctx, name)
+		if err != nil {
+			return res, err
+		}
+		res.Matched = len(existing)
+
+		// 同一次更新写入的多个条目共用一个批次号，回滚时一起恢复
+		rec := HistoryEntry{Batch: time.Now().UnixNano(), Source: src}
+		for _, e := range selectEnvs(existing, c.duplicates) {
+			if err := c.putEnv(ctx, e, mergeValue(e.Value, value, mode), rec); err != nil {
+				return res, err
+			}
+			res.Updated++
+		}
+		if res.Updated > 0 {
+			return res, nil
+		}
+
+		merged := mergeValue("", value, mode)
+		id, err := c.createEnv(ctx, name, merged)
+		if err != nil {
+			return res, err
+		}
+		recordHistory(HistoryEntry{Instance: c.Name(), Name: name, EnvID: id, New: merged, Created: true, Batch: rec.Batch, Source: src})
+		res.Created = true
+		return res, nil
+	}
+
+	var results []EnvResult
+	for _, n := range names {
+		res, err := updateSingle(n, value)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// selectEnvs 按策略挑出需要更新的同名变量，返回空表示需要新增
+func selectEnvs(envs []Env, policy DuplicatePolicy) []Env {
+	if len(envs) == 0 {
+		return nil
+	}
+	switch policy {
+	case DuplicateAll:
+		return envs
+	case DuplicateEnabled:
+		var enabled []Env
+		for _, e := range envs {
+			if e.Status == 0 {
+				enabled = append(enabled, e)
+			}
+		}
+		return enabled
+	case DuplicateCreate:
+		return nil
+	default:
+		return envs[:1]
+	}
+}
+
+// createEnv 新增变量并返回新条目的 ID（未知时为 nil），请求结果不明确时重新查询确认，避免重复新增
+func (c *Client) createEnv(ctx context.Context, name, value string) (*int64, error) {
+	// 新增：数组形式
+	data, err := json.Marshal([]Env{{Name: name, Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	const path = "/open/envs"
+	body, err := c.do(ctx, "POST", path, data)
+	if err == nil {
+		// 青龙返回新增的条目，解析失败不影响新增结果，只是无法记录 ID
+		var created []Env
+		if decode("POST", path, body, &created) == nil && len(created) > 0 {
+			return created[0].ID, nil
+		}
+		return nil, nil
+	}
+	if !errors.Is(err, ErrServer) && !isTransportError(err) {
+		return nil, err
+	}
+
+	log.Printf("⚠️ 新增变量 %s 结果未知，重新查询确认: %v", name, err)
+	existing, searchErr := c.searchEnvs(ctx, name)
+	if searchErr != nil {
+		return nil, err
+	}
+	for _, e := range existing {
+		if e.Value == value {
+			return e.ID, nil
+		}
+	}
+	return nil, err
+}
+
+// searchEnvs 按名称搜索环境变量，只保留名称完全相同的条目
+// 青龙的 searchValue 是模糊匹配，会同时命中名称前缀相同或值中包含该文本的变量
+func (c *Client) searchEnvs(ctx context.Context, name string) ([]Env, error) {
+	path := "/open/envs?searchValue=" + url.QueryEscape(name)
+	body, err := c.do(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var envs []Env
+	if err := decode("GET", path, body, &envs); err != nil {
+		return nil, err
+	}
+	exact := envs[:0]
+	for _, e := range envs {
+		if e.Name == name {
+			exact = append(exact, e)
+		}
+	}
+	return exact, nil
+}
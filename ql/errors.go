@@ -0,0 +1,132 @@
+package ql
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// 青龙请求错误分类，可配合 errors.Is 判断
+var (
+	ErrAuth        = errors.New("青龙鉴权失败")
+	ErrNotFound    = errors.New("青龙资源不存在")
+	ErrRateLimited = errors.New("青龙请求过于频繁")
+	ErrServer      = errors.New("青龙服务端错误")
+	ErrDecode      = errors.New("青龙响应解析失败")
+	ErrRequest     = errors.New("青龙拒绝请求")
+)
+
+// APIError 描述一次失败的青龙请求
+type APIError struct {
+	Kind   error // 上面的错误分类之一
+	Method string
+	Path   string
+	Status int    // HTTP 状态码或响应体中的 code
+	Body   string // 响应原文（截断）
+	Err    error  // 底层错误，例如 JSON 解析错误
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%v: %s %s", e.Kind, e.Method, e.Path)
+	if e.Status != 0 {
+		msg += fmt.Sprintf("，响应码: %d", e.Status)
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf("，%v", e.Err)
+	}
+	if e.Body != "" {
+		msg += "，响应: " + e.Body
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// 错误信息中保留的响应体长度上限
+const maxErrorBody = 512
+
+func truncateBody(body []byte) string {
+	if len(body) > maxErrorBody {
+		return string(body[:maxErrorBody]) + "..."
+	}
+	return string(body)
+}
+
+// classifyStatus 将 HTTP 状态码（或青龙响应体 code）映射为错误分类
+func classifyStatus(status int) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrServer
+	default:
+		return ErrRequest
+	}
+}
+
+func statusError(method, path string, status int, body []byte) error {
+	return &APIError{
+		Kind:   classifyStatus(status),
+		Method: method,
+		Path:   path,
+		Status: status,
+		Body:   truncateBody(body),
+	}
+}
+
+func decodeError(method, path string, body []byte, err error) error {
+	return &APIError{
+		Kind:   ErrDecode,
+		Method: method,
+		Path:   path,
+		Body:   truncateBody(body),
+		Err:    err,
+	}
+}
+
+// isIdempotent 判断请求重复发送是否安全
+// POST 会新增记录，脚本和定时任务运行会重复执行，均不能盲目重试
+func isIdempotent(method, path string) bool {
+	if method == http.MethodPost {
+		return false
+	}
+	switch path {
+	case "/open/scripts/run", "/open/crons/run":
+		return false
+	}
+	return true
+}
+
+// isTransportError 判断是否为未拿到 HTTP 响应的网络层错误
+func isTransportError(err error) bool {
+	var apiErr *APIError
+	return err != nil && !errors.As(err, &apiErr)
+}
+
+// retryable 判断失败的请求是否值得重试
+func retryable(err error, idempotent bool) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// 连接未建立，请求一定没有送达
+		return true
+	}
+	if errors.Is(err, ErrRateLimited) {
+		// 被限流的请求未被处理
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+	// 5xx 与网络层错误（超时、连接重置等）
+	return errors.Is(err, ErrServer) || isTransportError(err)
+}
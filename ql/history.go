@@ -0,0 +1,246 @@
+package ql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var historyFile = "./ql_env_history.json"
+
+const (
+	// 历史记录最多保留的条数
+	maxHistoryEntries = 1000
+	// 记录来源消息时保留的文本长度
+	maxSourceText = 200
+)
+
+// Source 变量更新的来源
+type Source struct {
+	PeerID    int64  `json:"peer_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Peer      string `json:"peer,omitempty"`
+	MessageID int    `json:"message_id,omitempty"`
+	Text      string `json:"text,omitempty"`
+}
+
+// NewSource 构造来源信息，消息文本会被截断
+func NewSource(peerID int64, username, peer string, messageID int, text string) Source {
+	if utf8.RuneCountInString(text) > maxSourceText {
+		text = string([]rune(text)[:maxSourceText]) + "..."
+	}
+	return Source{PeerID: peerID, Username: username, Peer: peer, MessageID: messageID, Text: text}
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Peer != "" && s.MessageID != 0:
+		return fmt.Sprintf("%s #%d", s.Peer, s.MessageID)
+	case s.Peer != "":
+		return s.Peer
+	default:
+		return "未知来源"
+	}
+}
+
+// HistoryEntry 一次变量写入记录
+type HistoryEntry struct {
+	Seq      int64  `json:"seq"`
+	Instance string `json:"instance,omitempty"`
+	Name     string `json:"name"`
+	EnvID    *int64 `json:"env_id,omitempty"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	Created  bool   `json:"created,omitempty"`  // 写入前变量不存在
+	Batch    int64  `json:"batch,omitempty"`    // 同一次更新写入的记录批次号相同
+	Rollback bool   `json:"rollback,omitempty"` // 由回滚或撤销写入，回滚时不计入步数
+	Time     int64  `json:"time"`               // Unix 时间戳
+	Source   Source `json:"source"`
+}
+
+var historyMu sync.Mutex
+
+func readHistory() ([]HistoryEntry, error) {
+	data, err := os.ReadFile(historyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func writeHistory(entries []HistoryEntry) error {
+	if len(entries) > maxHistoryEntries {
+		entries = entries[len(entries)-maxHistoryEntries:]
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(historyFile, data, 0644)
+}
+
+// recordHistory 追加一条写入记录，失败只打印日志不影响更新流程
+func recordHistory(e HistoryEntry) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	entries, err := readHistory()
+	if err != nil {
+		log.Printf("⚠️ 读取变量历史失败: %v", err)
+		return
+	}
+	e.Seq = 1
+	if n := len(entries); n > 0 {
+		e.Seq = entries[n-1].Seq + 1
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	if err := writeHistory(append(entries, e)); err != nil {
+		log.Printf("⚠️ 保存变量历史失败: %v", err)
+	}
+}
+
+// History 返回变量的写入记录（最新在前），name 为空时返回全部
+func History(name string) ([]HistoryEntry, error) {
+	historyMu.Lock()
+	entries, err := readHistory()
+	historyMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var out []HistoryEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if name == "" || entries[i].Name == name {
+			out = append(out, entries[i])
+		}
+	}
+	return out, nil
+}
+
+// Rollback 把变量恢复到本实例 steps 次更新之前的值
+// 回滚写入的记录不计入步数，重复执行得到相同的结果；一次更新写入了多个条目时全部恢复。
+// 若那次更新前变量并不存在，则禁用该变量
+func (c *Client) Rollback(ctx context.Context, name string, steps int) (*HistoryEntry, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	all, err := History(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取变量历史失败: %w", err)
+	}
+	var updates [][]HistoryEntry
+	for _, e := range all {
+		// 早期记录没有实例名，视为属于任意实例
+		if (e.Instance != c.Name() && e.Instance != "") || e.Rollback {
+			continue
+		}
+		if n := len(updates); n > 0 && e.Batch != 0 && updates[n-1][0].Batch == e.Batch {
+			updates[n-1] = append(updates[n-1], e)
+			continue
+		}
+		updates = append(updates, []HistoryEntry{e})
+	}
+	if len(updates) < steps {
+		return nil, fmt.Errorf("变量 %s 在 %s 只有 %d 次更新记录", name, c.Name(), len(updates))
+	}
+	group := updates[steps-1]
+	target := group[0]
+	src := Source{Peer: fmt.Sprintf("rollback #%d", target.Seq)}
+	for _, e := range group {
+		if err := c.restore(ctx, e, src); err != nil {
+			return &target, err
+		}
+	}
+	return &target, nil
+}
+
+// restore 把变量恢复为某条记录写入前的状态，写入的历史记录标记为回滚
+func (c *Client) restore(ctx context.Context, target HistoryEntry, src Source) error {
+	existing, err := c.searchEnvs(ctx, target.Name)
+	if err != nil {
+		return err
+	}
+
+	// 只恢复当时写入的那一条（按 ID，新增的按值）；条目已被删除时报错，
+	// 不能改动其他同名变量
+	envs := writtenEnvs(existing, target)
+	if len(envs) == 0 {
+		return fmt.Errorf("青龙中已找不到 #%d 写入的变量 %s", target.Seq, target.Name)
+	}
+
+	if target.Created {
+		if err := c.disableEnvs(ctx, envs); err != nil {
+			return err
+		}
+		log.Printf("↩️ 变量 %s 写入前不存在，已禁用 %d 个条目", target.Name, len(envs))
+		return nil
+	}
+
+	for _, e := range envs {
+		if err := c.putEnv(ctx, e, target.Old, HistoryEntry{Rollback: true, Source: src}); err != nil {
+			return err
+		}
+	}
+	log.Printf("↩️ 变量 %s 已恢复为 #%d 之前的值", target.Name, target.Seq)
+	return nil
+}
+
+// writtenEnvs 找出某条记录写入的变量：有 ID 的按 ID 匹配，没有记录 ID 的新增按值匹配
+func writtenEnvs(existing []Env, target HistoryEntry) []Env {
+	var envs []Env
+	for _, e := range existing {
+		switch {
+		case target.EnvID != nil:
+			if e.ID != nil && *e.ID == *target.EnvID {
+				envs = append(envs, e)
+			}
+		case e.Value == target.New:
+			envs = append(envs, e)
+		}
+	}
+	return envs
+}
+
+// disableEnvs 禁用指定的变量条目
+func (c *Client) disableEnvs(ctx context.Context, envs []Env) error {
+	var ids []int64
+	for _, e := range envs {
+		if e.ID != nil {
+			ids = append(ids, *e.ID)
+		}
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "PUT", "/open/envs/disable", data)
+	return err
+}
+
+// putEnv 更新单个已存在的变量并记录历史，rec 提供来源、批次等附加信息
+func (c *Client) putEnv(ctx context.Context, e Env, value string, rec HistoryEntry) error {
+	payload := Env{ID: e.ID, Name: e.Name, Value: value}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(ctx, "PUT", "/open/envs", data); err != nil {
+		return err
+	}
+	rec.Instance, rec.Name, rec.EnvID, rec.Old, rec.New = c.Name(), e.Name, e.ID, e.Value, value
+	recordHistory(rec)
+	return nil
+}
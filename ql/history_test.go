@@ -0,0 +1,111 @@
+package ql
+
+import (
+	"reflect"
+	"testing"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		seed      []Env
+		updates   []string
+		after     func(f *fakeQL) // 回滚前对青龙的手动修改
+		rollbacks []int           // 依次执行的回滚步数
+		want      []string        // 回滚后青龙中的 “ID:值/状态”
+		wantErr   bool
+	}{
+		{
+			name:      "回滚一步",
+			updates:   []string{"a", "b", "c"},
+			rollbacks: []int{1},
+			want:      []string{"100:b/0"},
+		},
+		{
+			name:      "回滚两步",
+			updates:   []string{"a", "b", "c"},
+			rollbacks: []int{2},
+			want:      []string{"100:a/0"},
+		},
+		{
+			name:      "回滚记录不计入步数",
+			updates:   []string{"a", "b", "c"},
+			rollbacks: []int{1, 1},
+			want:      []string{"100:b/0"},
+		},
+		{
+			name:      "写入前不存在的变量被禁用",
+			updates:   []string{"a", "b"},
+			rollbacks: []int{2},
+			want:      []string{"100:b/1"},
+		},
+		{
+			name:      "一次更新写入的多个条目全部恢复",
+			policy:    "all",
+			seed:      []Env{{ID: envID(1), Name: "jd_test", Value: "x"}, {ID: envID(2), Name: "jd_test", Value: "y"}},
+			updates:   []string{"a", "b"},
+			rollbacks: []int{2},
+			want:      []string{"1:x/0", "2:y/0"},
+		},
+		{
+			name:      "超出更新次数",
+			updates:   []string{"a"},
+			rollbacks: []int{2},
+			want:      []string{"100:a/0"},
+			wantErr:   true,
+		},
+		{
+			name:    "写入的条目已删除时不改动其他同名变量",
+			seed:    []Env{{ID: envID(1), Name: "jd_test", Value: "x"}},
+			updates: []string{"a"},
+			after: func(f *fakeQL) {
+				f.delete(1)
+				f.envs = append(f.envs, Env{ID: envID(2), Name: "jd_test", Value: "z"})
+			},
+			rollbacks: []int{1},
+			want:      []string{"2:z/0"},
+			wantErr:   true,
+		},
+		{
+			name:      "新增策略只恢复本次写入的条目",
+			policy:    "create",
+			seed:      []Env{{ID: envID(1), Name: "jd_test", Value: "x"}},
+			updates:   []string{"a"},
+			rollbacks: []int{1},
+			want:      []string{"1:x/0", "100:a/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir()) // 历史记录和 token 缓存写入当前目录
+			tokens = &tokenManager{}
+
+			f := newFakeQL(tt.seed...)
+			c := NewClient(utils.QLConfig{Name: "main", BaseURL: "http://ql.test", DuplicatePolicy: tt.policy},
+				WithTransport(f), WithRetries(0))
+			for _, v := range tt.updates {
+				if _, err := c.UpdateEnvNames(t.Context(), []string{"jd_test"}, v, Source{}); err != nil {
+					t.Fatalf("更新 %s 失败: %v", v, err)
+				}
+			}
+			if tt.after != nil {
+				tt.after(f)
+			}
+			var err error
+			for _, steps := range tt.rollbacks {
+				if _, err = c.Rollback(t.Context(), "jd_test", steps); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v，期望出错: %v", err, tt.wantErr)
+			}
+			if got := f.state(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("青龙变量 = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package ql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// RunningPolicy 脚本已在运行时再次触发的处理方式
+type RunningPolicy string
+
+const (
+	RunningIgnore  RunningPolicy = ""        // 不检查，直接触发（默认）
+	RunningSkip    RunningPolicy = "skip"    // 跳过本次触发
+	RunningQueue   RunningPolicy = "queue"   // 等当前运行结束后再触发
+	RunningRestart RunningPolicy = "restart" // 停止当前运行后重新触发
+)
+
+func parseRunningPolicy(s string) (RunningPolicy, error) {
+	switch p := RunningPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case RunningIgnore, RunningSkip, RunningQueue, RunningRestart:
+		return p, nil
+	default:
+		return RunningIgnore, fmt.Errorf("未知的运行中处理策略: %s", s)
+	}
+}
+
+// Suppressed 被抑制的触发
+type Suppressed struct {
+	Script ScriptInfo
+	Reason string
+}
+
+func (s Suppressed) String() string {
+	return fmt.Sprintf("%s (ID: %d)：%s", s.Script.Name, s.Script.ID, s.Reason)
+}
+
+// RunResult 一次 RunCrons 的处理结果
+type RunResult struct {
+	Started    []ScriptInfo // 已触发（含重启）
+	Queued     []ScriptInfo // 正在运行，等待结束后触发
+	Restarted  []ScriptInfo // 已停止当前运行并重新触发
+	Suppressed []Suppressed // 冷却、运行中或并发已满而未触发
+}
+
+// admit 按冷却时间、运行中策略和并发上限筛选本次真正需要触发的脚本
+// 通过冷却检查的脚本立即预占 lastRun，避免并发的消息在查询运行状态期间重复触发；
+// 最终未触发的脚本撤销预占。返回的 Started 已占用并发名额，需由 trackRuns 或 release 归还
+func (c *Client) admit(ctx context.Context, scripts []ScriptInfo) *RunResult {
+	res := &RunResult{}
+
+	c.runMu.Lock()
+	now := time.Now()
+	prev := make(map[int]time.Time) // 预占前的 lastRun，零值表示没有记录
+	var candidates []ScriptInfo
+	for _, s := range scripts {
+		if c.queued[s.ID] {
+			res.Suppressed = append(res.Suppressed, Suppressed{s, "已在等待队列中"})
+			continue
+		}
+		last, ok := c.lastRun[s.ID]
+		if ok && c.cooldown > 0 && now.Sub(last) < c.cooldown {
+			remain := (c.cooldown - now.Sub(last)).Round(time.Second)
+			res.Suppressed = append(res.Suppressed, Suppressed{s, fmt.Sprintf("冷却中，剩余 %s", remain)})
+			continue
+		}
+		prev[s.ID] = last
+		c.lastRun[s.ID] = now
+		candidates = append(candidates, s)
+	}
+	c.runMu.Unlock()
+
+	var dropped []ScriptInfo // 预占后因运行中或停止失败而未触发
+	if c.ifRunning != RunningIgnore && len(candidates) > 0 {
+		var restart []ScriptInfo
+		idle := candidates[:0]
+		for _, s := range candidates {
+			d, err := c.cronDetail(ctx, s.ID)
+			if err != nil || (d.Status != cronRunning && d.Status != cronQueued) {
+				idle = append(idle, s)
+				continue
+			}
+			switch c.ifRunning {
+			case RunningSkip:
+				res.Suppressed = append(res.Suppressed, Suppressed{s, "正在运行"})
+				dropped = append(dropped, s)
+			case RunningQueue:
+				res.Queued = append(res.Queued, s)
+				dropped = append(dropped, s)
+			case RunningRestart:
+				restart = append(restart, s)
+			}
+		}
+		candidates = idle
+
+		if len(restart) > 0 {
+			if err := c.stopCrons(ctx, restart); err != nil {
+				log.Printf("⚠️ [%s] 停止运行中的脚本失败: %v", c.Name(), err)
+				for _, s := range restart {
+					res.Suppressed = append(res.Suppressed, Suppressed{s, "正在运行，停止失败"})
+				}
+				dropped = append(dropped, restart...)
+			} else {
+				res.Restarted = restart
+				// 之前的跟踪会看到被停止的运行结束，需要记为已停止而不是成功
+				c.runMu.Lock()
+				stoppedAt := time.Now()
+				for _, s := range restart {
+					c.stopped[s.ID] = stoppedAt
+				}
+				c.runMu.Unlock()
+			}
+		}
+	}
+
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if c.maxConcurrent > 0 {
+		free := c.maxConcurrent - c.active
+		if free < 0 {
+			free = 0
+		}
+		if len(candidates) > free {
+			for _, s := range candidates[free:] {
+				res.Suppressed = append(res.Suppressed, Suppressed{s, fmt.Sprintf("并发已满（%d）", c.maxConcurrent)})
+			}
+			dropped = append(dropped, candidates[free:]...)
+			candidates = candidates[:free]
+		}
+	}
+	for _, s := range dropped {
+		c.unreserve(s.ID, now, prev[s.ID])
+	}
+	// 重启的脚本替换原有运行，不受并发上限限制
+	candidates = append(candidates, res.Restarted...)
+	if c.trackTimeout > 0 {
+		c.active += len(candidates)
+	}
+	queued := res.Queued[:0]
+	for _, s := range res.Queued {
+		if c.queued[s.ID] {
+			// 查询运行状态期间已被其他消息加入队列
+			res.Suppressed = append(res.Suppressed, Suppressed{s, "已在等待队列中"})
+			continue
+		}
+		c.queued[s.ID] = true
+		queued = append(queued, s)
+	}
+	res.Queued = queued
+	res.Started = candidates
+	return res
+}
+
+// unreserve 撤销 admit 对 lastRun 的预占，调用方需持有 runMu
+// 预占之后 lastRun 已被更新（例如排队的触发已执行）时保持不变
+func (c *Client) unreserve(id int, at, prev time.Time) {
+	if !c.lastRun[id].Equal(at) {
+		return
+	}
+	if prev.IsZero() {
+		delete(c.lastRun, id)
+	} else {
+		c.lastRun[id] = prev
+	}
+}
+
+// stoppedSince 脚本在 since 之后是否被重启策略停止过
+func (c *Client) stoppedSince(id int, since time.Time) bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	t, ok := c.stopped[id]
+	return ok && !t.Before(since)
+}
+
+// release 归还并发名额；触发失败时同时清除冷却记录，允许下次重试
+func (c *Client) release(scripts []ScriptInfo, failed bool) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	for _, s := range scripts {
+		if c.trackTimeout > 0 && c.active > 0 {
+			c.active--
+		}
+		if failed {
+			delete(c.lastRun, s.ID)
+		}
+	}
+}
+
+// stopCrons 停止正在运行的脚本
+func (c *Client) stopCrons(ctx context.Context, scripts []ScriptInfo) error {
+	var ids []int
+	for _, s := range scripts {
+		ids = append(ids, s.ID)
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "PUT", "/open/crons/stop", data)
+	return err
+}
+
+// runWhenIdle 等待脚本当前运行结束且有空闲并发名额后再触发一次，不受冷却时间限制
+func (c *Client) runWhenIdle(ctx context.Context, s ScriptInfo) {
+	defer func() {
+		c.runMu.Lock()
+		delete(c.queued, s.ID)
+		c.runMu.Unlock()
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, c.queueTimeout())
+	defer cancel()
+	ticker := time.NewTicker(c.trackInterval)
+	defer ticker.Stop()
+	for admitted := false; !admitted; {
+		select {
+		case <-waitCtx.Done():
+			log.Printf("⚠️ [%s] 等待脚本 %s 结束或并发名额超时，放弃排队", c.Name(), s.Name)
+			return
+		case <-ticker.C:
+		}
+		d, err := c.cronDetail(waitCtx, s.ID)
+		if err != nil || d.Status == cronRunning || d.Status == cronQueued {
+			continue
+		}
+
+		// 与 admit 一样占用并发名额，已满时继续等待
+		c.runMu.Lock()
+		if admitted = c.maxConcurrent <= 0 || c.active < c.maxConcurrent; admitted {
+			c.lastRun[s.ID] = time.Now()
+			if c.trackTimeout > 0 {
+				c.active++
+			}
+		}
+		c.runMu.Unlock()
+	}
+
+	log.Printf("▶️ [%s] 脚本 %s 已结束，执行排队的触发", c.Name(), s.Name)
+
+	scripts := []ScriptInfo{s}
+	if err := c.runCrons(ctx, scripts); err != nil {
+		c.release(scripts, true)
+		log.Printf("❌ [%s] 排队脚本 %s 触发失败: %v", c.Name(), s.Name, err)
+	}
+}
+
+func (c *Client) queueTimeout() time.Duration {
+	if c.trackTimeout > 0 {
+		return c.trackTimeout
+	}
+	return defaultTrackTimeout
+}
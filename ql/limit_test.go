@@ -0,0 +1,85 @@
+package ql
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestRunCronsConcurrentCooldown(t *testing.T) {
+	t.Chdir(t.TempDir()) // token 缓存和脚本统计写入当前目录
+	tokens = &tokenManager{}
+
+	rt := &stubTransport{
+		status: http.StatusOK,
+		bodies: map[string]string{"GET /open/crons/1": `{"code":200,"data":{"id":1,"status":1}}`},
+		delay:  50 * time.Millisecond,
+		calls:  make(map[string]int),
+	}
+	c := NewClient(utils.QLConfig{
+		BaseURL:      "http://ql.test",
+		ClientID:     "id",
+		Cooldown:     300,
+		IfRunning:    "skip",
+		TrackTimeout: -1,
+	}, WithTransport(rt))
+
+	// 两个频道同时发布同一个活动
+	script := ScriptInfo{ID: 1, Name: "jd_test"}
+	var wg sync.WaitGroup
+	results := make([]*RunResult, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.RunCrons(t.Context(), []ScriptInfo{script})
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	if got := rt.count("PUT /open/crons/run"); got != 1 {
+		t.Errorf("触发次数 = %d，期望 1", got)
+	}
+	started, suppressed := 0, 0
+	for _, res := range results {
+		started += len(res.Started)
+		suppressed += len(res.Suppressed)
+	}
+	if started != 1 || suppressed != 1 {
+		t.Errorf("触发 %d 个，抑制 %d 个，期望各 1 个", started, suppressed)
+	}
+}
+
+func TestAdmitUndoesReservationOnSkip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tokens = &tokenManager{}
+
+	rt := &stubTransport{
+		status: http.StatusOK,
+		bodies: map[string]string{"GET /open/crons/1": `{"code":200,"data":{"id":1,"status":0}}`},
+		calls:  make(map[string]int),
+	}
+	c := NewClient(utils.QLConfig{
+		BaseURL:      "http://ql.test",
+		ClientID:     "id",
+		Cooldown:     300,
+		IfRunning:    "skip",
+		TrackTimeout: -1,
+	}, WithTransport(rt))
+
+	// 正在运行而被跳过的脚本不进入冷却，结束后的下一条消息仍可触发
+	res := c.admit(t.Context(), []ScriptInfo{{ID: 1, Name: "jd_test"}})
+	if len(res.Started) != 0 || len(res.Suppressed) != 1 {
+		t.Fatalf("Started = %v，Suppressed = %v，期望跳过", res.Started, res.Suppressed)
+	}
+	if _, ok := c.lastRun[1]; ok {
+		t.Error("跳过的脚本仍保留冷却记录")
+	}
+}
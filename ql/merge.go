@@ -0,0 +1,153 @@
+package ql
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"telegram-env-watcher/utils"
+)
+
+// MergeMode 多值变量的合并方式
+type MergeMode string
+
+const (
+	MergeReplace  MergeMode = "replace"   // 直接覆盖（默认）
+	MergeAppend   MergeMode = "append"    // 追加到末尾，去重
+	MergePrepend  MergeMode = "prepend"   // 插入到开头，去重
+	MergeKeepLast MergeMode = "keep_last" // 追加后只保留最后 N 个
+)
+
+// 未配置分隔符时使用 &
+const defaultSeparator = "&"
+
+// EnvMode 单个变量生效的合并规则
+type EnvMode struct {
+	Mode      MergeMode
+	Separator string
+	Keep      int
+}
+
+func (m EnvMode) String() string {
+	switch m.Mode {
+	case MergeAppend:
+		return "追加"
+	case MergePrepend:
+		return "前插"
+	case MergeKeepLast:
+		return fmt.Sprintf("保留最后 %d 个", m.Keep)
+	default:
+		return "覆盖"
+	}
+}
+
+func parseMergeMode(s string) (MergeMode, error) {
+	switch m := MergeMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return MergeReplace, nil
+	case MergeReplace, MergeAppend, MergePrepend, MergeKeepLast:
+		return m, nil
+	default:
+		return MergeReplace, fmt.Errorf("未知的变量合并方式: %s", s)
+	}
+}
+
+// envModeRule 编译后的配置规则，Pattern 支持 * ? 通配符
+type envModeRule struct {
+	Pattern string
+	EnvMode
+}
+
+func compileEnvModes(cfgs []utils.EnvModeConfig) []envModeRule {
+	var rules []envModeRule
+	for _, m := range cfgs {
+		mode, err := parseMergeMode(m.Mode)
+		if err != nil {
+			log.Printf("⚠️ %v（变量: %s），按覆盖处理", err, m.Name)
+		}
+		if mode == MergeKeepLast && m.Keep <= 0 {
+			log.Printf("⚠️ keep_last 需要配置大于 0 的 keep（变量: %s），按追加处理", m.Name)
+			mode = MergeAppend
+		}
+		if _, err := path.Match(m.Name, ""); err != nil {
+			log.Printf("⚠️ 变量合并规则名称无效: %s", m.Name)
+			continue
+		}
+		sep := m.Separator
+		if sep == "" {
+			sep = defaultSeparator
+		}
+		rules = append(rules, envModeRule{
+			Pattern: m.Name,
+			EnvMode: EnvMode{Mode: mode, Separator: sep, Keep: m.Keep},
+		})
+	}
+	return rules
+}
+
+// modeFor 返回第一条匹配变量名的规则，无匹配时直接覆盖
+func (c *Client) modeFor(name string) EnvMode {
+	for _, r := range c.modes {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			return r.EnvMode
+		}
+	}
+	return EnvMode{Mode: MergeReplace, Separator: defaultSeparator}
+}
+
+// mergeValue 按规则把新值合并进当前值
+func mergeValue(current, incoming string, m EnvMode) string {
+	if m.Mode == MergeReplace {
+		return incoming
+	}
+
+	oldItems := splitValue(current, m.Separator)
+	newItems := splitValue(incoming, m.Separator)
+
+	var merged []string
+	if m.Mode == MergePrepend {
+		merged = uniqueItems(append(newItems, oldItems...))
+		if m.Keep > 0 && len(merged) > m.Keep {
+			merged = merged[:m.Keep]
+		}
+	} else {
+		// 新值中已存在的条目移到末尾，保持“最新在后”
+		fresh := make(map[string]bool, len(newItems))
+		for _, it := range newItems {
+			fresh[it] = true
+		}
+		for _, it := range oldItems {
+			if !fresh[it] {
+				merged = append(merged, it)
+			}
+		}
+		merged = uniqueItems(append(merged, newItems...))
+		if m.Keep > 0 && len(merged) > m.Keep {
+			merged = merged[len(merged)-m.Keep:]
+		}
+	}
+	return strings.Join(merged, m.Separator)
+}
+
+func splitValue(v, sep string) []string {
+	var items []string
+	for _, it := range strings.Split(v, sep) {
+		if it = strings.TrimSpace(it); it != "" {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+func uniqueItems(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	out := items[:0]
+	for _, it := range items {
+		if !seen[it] {
+			seen[it] = true
+			out = append(out, it)
+		}
+	}
+	return out
+}
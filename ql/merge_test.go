@@ -0,0 +1,98 @@
+package ql
+
+import (
+	"testing"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestMergeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		incoming string
+		mode     EnvMode
+		want     string
+	}{
+		{
+			name:     "覆盖",
+			current:  "a&b",
+			incoming: "c",
+			mode:     EnvMode{Mode: MergeReplace, Separator: "&"},
+			want:     "c",
+		},
+		{
+			name:     "追加时重复条目移到末尾",
+			current:  "a&b&c",
+			incoming: "a",
+			mode:     EnvMode{Mode: MergeAppend, Separator: "&"},
+			want:     "b&c&a",
+		},
+		{
+			name:     "追加新值内部去重",
+			current:  "a",
+			incoming: "b&b&c",
+			mode:     EnvMode{Mode: MergeAppend, Separator: "&"},
+			want:     "a&b&c",
+		},
+		{
+			name:     "前插并限制数量",
+			current:  "a&b&c",
+			incoming: "d&b",
+			mode:     EnvMode{Mode: MergePrepend, Separator: "&", Keep: 3},
+			want:     "d&b&a",
+		},
+		{
+			name:     "保留最后 N 个",
+			current:  "a&b&c",
+			incoming: "d&e",
+			mode:     EnvMode{Mode: MergeKeepLast, Separator: "&", Keep: 3},
+			want:     "c&d&e",
+		},
+		{
+			name:     "当前值为空",
+			current:  "",
+			incoming: "a&b",
+			mode:     EnvMode{Mode: MergeAppend, Separator: "&"},
+			want:     "a&b",
+		},
+		{
+			name:     "当前值为空时前插",
+			current:  "",
+			incoming: "a",
+			mode:     EnvMode{Mode: MergePrepend, Separator: "\n"},
+			want:     "a",
+		},
+		{
+			name:     "自定义分隔符并去除空白",
+			current:  "a\n b \n",
+			incoming: "c",
+			mode:     EnvMode{Mode: MergeAppend, Separator: "\n"},
+			want:     "a\nb\nc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeValue(tt.current, tt.incoming, tt.mode); got != tt.want {
+				t.Errorf("mergeValue(%q, %q) = %q，期望 %q", tt.current, tt.incoming, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileEnvModesKeepLastWithoutKeep(t *testing.T) {
+	rules := compileEnvModes([]utils.EnvModeConfig{
+		{Name: "A", Mode: "keep_last"},
+		{Name: "B", Mode: "keep_last", Keep: -1},
+		{Name: "C", Mode: "keep_last", Keep: 2},
+	})
+	want := []MergeMode{MergeAppend, MergeAppend, MergeKeepLast}
+	if len(rules) != len(want) {
+		t.Fatalf("规则数 = %d，期望 %d", len(rules), len(want))
+	}
+	for i, r := range rules {
+		if r.Mode != want[i] {
+			t.Errorf("%s: 合并方式 = %s，期望 %s", r.Pattern, r.Mode, want[i])
+		}
+	}
+}
@@ -1,30 +1,22 @@
 package ql
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"strings"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
-
-	"telegram-env-watcher/utils"
 )
 
 type Env struct {
-    ID    *int64 `json:"id,omitempty"`  // 用指针，omitempty 让它为空时不序列化
-    Name  string `json:"name"`
-    Value string `json:"value"`
-}
-
-type tokenResp struct {
-	Code int `json:"code"`
-	Data struct {
-		Token string `json:"token"`
-	} `json:"data"`
+	ID     *int64 `json:"id,omitempty"` // 用指针，omitempty 让它为空时不序列化
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Status int    `json:"status,omitempty"` // 0 启用，1 禁用
 }
 
 type ScriptInfo struct {
@@ -41,12 +33,17 @@ func getStatsFile() string {
 }
 
 type DailyStats struct {
-	Total   int      `json:"total"`
-	Success int      `json:"success"`
-	Fail    int      `json:"fail"`
-	Errors  []string `json:"errors"`
+	Total      int       `json:"total"`
+	Success    int       `json:"success"`
+	Fail       int       `json:"fail"`
+	Suppressed int       `json:"suppressed"` // 因冷却、运行中或并发上限未触发
+	Stopped    int       `json:"stopped"`    // 被重启策略停止的运行
+	Errors     []string  `json:"errors"`
+	FailedRuns []CronRun `json:"failed_runs,omitempty"` // 实际运行失败的脚本
 }
 
+var statsMu sync.Mutex
+
 func readDailyStats() (*DailyStats, error) {
 	file := getStatsFile()
 	data, err := os.ReadFile(file)
@@ -71,131 +68,53 @@ func writeDailyStats(stats *DailyStats) error {
 	return os.WriteFile(getStatsFile(), data, 0644)
 }
 
-type NotifyEntry struct {
-	Title string `json:"title"`
-	Body  string `json:"body"`
-	Time  int64  `json:"time"` // Unix 时间戳
-}
-
-func GetQLToken(cfg *utils.Config) (string, error) {
-	url := fmt.Sprintf("%s/open/auth/token?client_id=%s&client_secret=%s",
-		cfg.QL.BaseURL, cfg.QL.ClientID, cfg.QL.ClientSecret)
-	if cfg.Debug {
-		log.Printf("🔗 请求地址: %s\n", url)
-	}
-	resp, err := http.Get(url)
+// updateDailyStats 读取-修改-写回统计文件，可在多个 goroutine 中调用
+func updateDailyStats(fn func(stats *DailyStats)) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats, err := readDailyStats()
 	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	var r tokenResp
-	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
-		return "", err
+		log.Printf("❌ 读取脚本统计失败: %v", err)
+		return
 	}
-	if r.Code != 200 {
-		return "", fmt.Errorf("❌ 获取 token 失败，响应码: %d", r.Code)
+	fn(stats)
+	if err := writeDailyStats(stats); err != nil {
+		log.Printf("❌ 保存脚本统计失败: %v", err)
 	}
-	return r.Data.Token, nil
 }
 
-func UpdateQLEnv(cfg *utils.Config, name, value string) error {
-	// 定义一个内部函数，单次更新逻辑
-	updateSingle := func(name, value string) error {
-		token, err := GetQLToken(cfg)
-		if err != nil {
-			return err
-		}
-
-		searchURL := fmt.Sprintf("%s/open/envs?searchValue=%s", cfg.QL.BaseURL, name)
-		req, _ := http.NewRequest("GET", searchURL, nil)
-		req.Header.Set("Authorization", "Bearer "+token)
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		var search struct {
-			Data []Env `json:"data"`
-		}
-		body, _ := ioutil.ReadAll(resp.Body)
-		_ = json.Unmarshal(body, &search)
-
-		var (
-			data   []byte
-			method string
-			url    = fmt.Sprintf("%s/open/envs", cfg.QL.BaseURL)
-		)
-		if len(search.Data) > 0 {
-			// 更新：单个对象
-			payload := Env{ID: search.Data[0].ID, Name: name, Value: value}
-			data, err = json.Marshal(payload)
-			if err != nil {
-				return err
-			}
-			method = "PUT"
-		} else {
-			// 新增：数组形式
-			payload := []Env{{Name: name, Value: value}}
-			data, err = json.Marshal(payload)
-			if err != nil {
-				return err
-			}
-			method = "POST"
-		}
-
-		if cfg.Debug {
-			log.Printf("🔗 请求地址: %s\n", url)
-			log.Printf("📦 请求方法: %s\n", method)
-			log.Printf("🔐 Authorization: Bearer %s\n", token)
-			log.Printf("📝 请求 Body: %s\n", string(data))
-		}
-		req, _ = http.NewRequest(method, url, bytes.NewBuffer(data))
-		req.Header.Set("Authorization", "Bearer "+token)
-		req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode >= 300 {
-			b, _ := ioutil.ReadAll(resp.Body)
-			log.Printf("❌ 青龙响应失败：%s", string(b))
-			return fmt.Errorf("青龙响应码: %d", resp.StatusCode)
-		}
-		return nil
+// String 构造统计推送内容
+func (stats *DailyStats) String() string {
+	msg := fmt.Sprintf("📌【脚本统计】\n🔵 总执行: %d\n✅ 成功: %d\n❌ 失败: %d", stats.Total, stats.Success, stats.Fail)
+	if stats.Stopped > 0 {
+		msg += fmt.Sprintf("\n⏹ 已停止重启: %d", stats.Stopped)
 	}
-
-	// 调用两次逻辑
-	err := updateSingle(name, value)
-	if err != nil {
-		return err
+	if pending := stats.Total - stats.Success - stats.Fail - stats.Stopped; pending > 0 {
+		msg += fmt.Sprintf("\n⏳ 未知/运行中: %d", pending)
 	}
-
-	// 如果包含 lzkj，尝试更新替换后的变量
-	if strings.Contains(name, "lzkj") {
-		nameV2 := strings.Replace(name, "lzkj", "lzkj_v2", 1)
-		if nameV2 != name {
-			err = updateSingle(nameV2, value)
-			if err != nil {
-				return err
-			}
+	if stats.Suppressed > 0 {
+		msg += fmt.Sprintf("\n⏸ 已抑制: %d", stats.Suppressed)
+	}
+	if len(stats.FailedRuns) > 0 {
+		msg += "\n\n🚫 运行失败的脚本:"
+		for _, r := range stats.FailedRuns {
+			msg += "\n➖ " + r.String()
 		}
 	}
-
-	return nil
-}
-
-func RunScriptContent(cfg *utils.Config, filename, path, content string) error {
-	token, err := GetQLToken(cfg)
-	if err != nil {
-		return err
+	if len(stats.Errors) > 0 {
+		msg += "\n\n🚫 错误信息:\n➖ " + strings.Join(stats.Errors, "\n➖ ")
 	}
+	return msg
+}
 
-	url := fmt.Sprintf("%s/open/scripts/run", cfg.QL.BaseURL)
+type NotifyEntry struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Time  int64  `json:"time"` // Unix 时间戳
+}
 
+// RunScriptContent 通过 /open/scripts/run 执行一段脚本内容
+func (c *Client) RunScriptContent(ctx context.Context, filename, path, content string) error {
 	payload := map[string]string{
 		"filename": filename,
 	}
@@ -213,33 +132,11 @@ func RunScriptContent(cfg *utils.Config, filename, path, content string) error {
 		return err
 	}
 
-	if cfg.Debug {
-		log.Printf("🔗 请求地址: %s\n", url)
-		log.Printf("📦 请求方法: PUT\n")
-		log.Printf("🔐 Authorization: Bearer %s\n", token)
-		log.Printf("📝 请求 Body: %s\n", string(data))
-	}
-
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(data))
-	if err != nil {
+	if _, err := c.do(ctx, "PUT", "/open/scripts/run", data); err != nil {
+		log.Printf("❌ 青龙脚本运行失败：%v", err)
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode >= 300 {
-		log.Printf("❌ 青龙脚本运行失败：%s", string(respBody))
-		return fmt.Errorf("青龙响应码: %d", resp.StatusCode)
-	}
-
 	log.Printf("✅ 青龙脚本运行成功\n")
 	return nil
 }
@@ -251,99 +148,88 @@ func RenderTemplate(tpl string, vars map[string]string) string {
 	return tpl
 }
 
-func SendNotifyNowViaQL(cfg *utils.Config, title string, body string) error {
-	content := RenderTemplate(cfg.QL.Notify.Template, map[string]string{
+// SendNotifyNow 立即通过青龙通知脚本发送消息
+// 本实例未配置通知脚本时改由主实例发送，标题中注明来源实例
+func (c *Client) SendNotifyNow(ctx context.Context, title string, body string) error {
+	if c.cfg.Notify.ScriptFile == "" {
+		if c.notifier == nil || c.notifier == c {
+			return fmt.Errorf("青龙实例 %s 未配置通知脚本", c.Name())
+		}
+		return c.notifier.SendNotifyNow(ctx, fmt.Sprintf("[%s] %s", c.Name(), title), body)
+	}
+	content := RenderTemplate(c.cfg.Notify.Template, map[string]string{
 		"title": title,
 		"body":  body,
 	})
-	return RunScriptContent(cfg,
-		cfg.QL.Notify.ScriptFile,
-		cfg.QL.Notify.ScriptPath,
+	return c.RunScriptContent(ctx,
+		c.cfg.Notify.ScriptFile,
+		c.cfg.Notify.ScriptPath,
 		content,
 	)
 }
 
-func SearchCrons(cfg *utils.Config, keyword string) ([]ScriptInfo, error) {
-	token, err := GetQLToken(cfg)
+// SearchCrons 按关键字搜索定时任务
+func (c *Client) SearchCrons(ctx context.Context, keyword string) ([]ScriptInfo, error) {
+	path := "/open/crons?searchValue=" + url.QueryEscape(keyword)
+	if c.debug {
+		log.Printf("🔎 搜索脚本: %s", c.cfg.BaseURL+path)
+	}
+
+	body, err := c.do(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// 构建搜索关键字列表（包含扩展规则）
-	keywords := []string{keyword}
-	if strings.Contains(keyword, "lzkj") {
-		v2 := strings.Replace(keyword, "lzkj", "lzkj_v2", 1)
-		keywords = append(keywords, v2)
+	var result struct {
+		Data  []ScriptInfo `json:"data"`
+		Total int          `json:"total"`
 	}
-
-	var allScripts []ScriptInfo
-	seen := make(map[int]bool) // 避免重复 ID
-
-	for _, kw := range keywords {
-		url := fmt.Sprintf("%s/open/crons?searchValue=%s", cfg.QL.BaseURL, kw)
-		if cfg.Debug {
-			log.Printf("🔎 搜索脚本: %s", url)
-		}
-
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode >= 300 {
-			body, _ := ioutil.ReadAll(resp.Body)
-			log.Printf("❌ 搜索失败（%s）：%s", kw, string(body))
-			continue // 不返回错误，继续尝试其他关键词
-		}
-
-		var result struct {
-			Code int `json:"code"`
-			Data struct {
-				Data  []ScriptInfo `json:"data"`
-				Total int          `json:"total"`
-			} `json:"data"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			log.Printf("❌ 解码失败（%s）: %v", kw, err)
-			continue
-		}
-
-		for _, s := range result.Data.Data {
-			if !seen[s.ID] {
-				allScripts = append(allScripts, s)
-				seen[s.ID] = true
-			}
-		}
+	if err := decode("GET", path, body, &result); err != nil {
+		return nil, err
 	}
 
-	if cfg.Debug {
-		log.Printf("📦 总共获取到 %d 个脚本（关键词: %v）", len(allScripts), keywords)
-		for _, s := range allScripts {
+	if c.debug {
+		log.Printf("📦 总共获取到 %d 个脚本（关键词: %s）", len(result.Data), keyword)
+		for _, s := range result.Data {
 			log.Printf("🔧 脚本: id=%d name=%s command=%s", s.ID, s.Name, s.Command)
 		}
 	}
 
-	return allScripts, nil
+	return result.Data, nil
 }
 
-func RunCrons(cfg *utils.Config, scripts []ScriptInfo) error {
-	// 更新每日统计：总次数
-	stats, _ := readDailyStats()
-	stats.Total += len(scripts)
-	_ = writeDailyStats(stats)
+// RunCrons 按冷却时间、运行中策略和并发上限筛选后触发执行，并在后台跟踪实际运行结果
+func (c *Client) RunCrons(ctx context.Context, scripts []ScriptInfo) (*RunResult, error) {
+	res := c.admit(ctx, scripts)
+	for _, s := range res.Suppressed {
+		log.Printf("⏸ [%s] 跳过脚本 %s", c.Name(), s)
+	}
+	if len(res.Suppressed) > 0 {
+		updateDailyStats(func(stats *DailyStats) {
+			stats.Suppressed += len(res.Suppressed)
+		})
+	}
+	for _, s := range res.Queued {
+		log.Printf("⏳ [%s] 脚本 %s 正在运行，结束后再触发", c.Name(), s.Name)
+		go c.runWhenIdle(context.WithoutCancel(ctx), s)
+	}
 
-	token, err := GetQLToken(cfg)
-	if err != nil {
-		return err
+	if len(res.Started) == 0 {
+		return res, nil
+	}
+	if err := c.runCrons(ctx, res.Started); err != nil {
+		c.release(res.Started, true)
+		return res, err
 	}
+	return res, nil
+}
+
+// runCrons 直接触发执行一组定时任务
+func (c *Client) runCrons(ctx context.Context, scripts []ScriptInfo) error {
+	// 更新每日统计：总次数
+	updateDailyStats(func(stats *DailyStats) {
+		stats.Total += len(scripts)
+	})
 
 	var ids []int
 	log.Printf("🚀 即将执行脚本 (%d 个):", len(scripts))
@@ -357,50 +243,44 @@ func RunCrons(cfg *utils.Config, scripts []ScriptInfo) error {
 		return fmt.Errorf("❌ 编码 ID 列表失败: %v", err)
 	}
 
-	url := fmt.Sprintf("%s/open/crons/run", cfg.QL.BaseURL)
-	if cfg.Debug {
-		log.Printf("📤 PUT 请求 URL: %s", url)
-		log.Printf("📤 PUT 请求体 JSON: %s", string(bodyBytes))
+	var baselines map[int]int64
+	if c.trackTimeout > 0 {
+		baselines = c.cronBaselines(ctx, scripts)
 	}
+	started := time.Now()
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(bodyBytes))
+	respBody, err := c.do(ctx, "PUT", "/open/crons/run", bodyBytes)
 	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode >= 300 {
 		// 统计失败
-		stats, _ := readDailyStats()
-		stats.Fail += len(scripts)
-		stats.Errors = append(stats.Errors, string(respBody))
-		_ = writeDailyStats(stats)
+		updateDailyStats(func(stats *DailyStats) {
+			stats.Fail += len(scripts)
+			stats.Errors = append(stats.Errors, err.Error())
+		})
 		// 实时错误推送
-		SendNotifyNowViaQL(cfg, "脚本执行失败", string(respBody))
-		return fmt.Errorf("❌ 执行失败，状态码: %d，响应: %s", resp.StatusCode, string(respBody))
+		c.SendNotifyNow(ctx, "脚本执行失败", err.Error())
+		return fmt.Errorf("❌ 执行失败: %w", err)
 	}
 
-	// 统计成功
-	stats, _ = readDailyStats()
-	stats.Success += len(scripts)
-	_ = writeDailyStats(stats)
+	if c.debug {
+		log.Printf("✅ 已加入运行队列: %s", string(respBody))
+	}
 
-	if cfg.Debug {
-		log.Printf("✅ 执行成功: %s", string(respBody))
+	if c.trackTimeout <= 0 {
+		// 未开启跟踪，按触发成功统计
+		updateDailyStats(func(stats *DailyStats) {
+			stats.Success += len(scripts)
+		})
+		c.release(scripts, false)
+		return nil
 	}
 
+	// 跟踪不受本次消息处理的 ctx 取消影响
+	go c.trackRuns(context.WithoutCancel(ctx), scripts, baselines, started)
 	return nil
 }
 
-func SendNotifyViaQL(cfg *utils.Config, title string, body string) error {
+// SendNotifyViaQL 将通知写入本地缓冲，由 FlushNotifyBuffer 合并发送
+func SendNotifyViaQL(title string, body string) error {
 	entry := NotifyEntry{
 		Title: title,
 		Body:  body,
@@ -420,26 +300,36 @@ func SendNotifyViaQL(cfg *utils.Config, title string, body string) error {
 	return os.WriteFile(notifyCacheFile, data, 0644)
 }
 
+// sleepUntil 等待到指定时间，ctx 取消时返回 false
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 // 每天9:10定时推送统计并清理文件
 // 启动时主动推送一次每日统计（不清空文件）
-func PushStatsOnce(cfg *utils.Config) {
+func (c *Client) PushStatsOnce(ctx context.Context) {
+	statsMu.Lock()
 	stats, err := readDailyStats()
+	statsMu.Unlock()
 	if err != nil {
 		log.Printf("❌ 读取脚本统计失败: %v", err)
 		return
 	}
-	if stats.Total > 0 {
-		msg := fmt.Sprintf("📌【脚本统计】\n🔵 总执行: %d\n✅ 成功: %d\n❌ 失败: %d", stats.Total, stats.Success, stats.Fail)
-		if len(stats.Errors) > 0 {
-			msg += "\n\n🚫 错误信息:\n➖ " + strings.Join(stats.Errors, "\n➖ ")
-		}
-		if err := SendNotifyNowViaQL(cfg, "📥 每日脚本执行统计", msg); err != nil {
+	if stats.Total > 0 || stats.Suppressed > 0 {
+		if err := c.SendNotifyNow(ctx, "📥 每日脚本执行统计", stats.String()); err != nil {
 			log.Printf("❌ 推送脚本统计失败: %v", err)
 		}
 	}
 }
 
-func StartStatsScheduler(cfg *utils.Config) {
+func (c *Client) StartStatsScheduler(ctx context.Context) {
 	go func() {
 		for {
 			now := time.Now()
@@ -448,25 +338,13 @@ func StartStatsScheduler(cfg *utils.Config) {
 			if now.After(next) {
 				next = next.Add(24 * time.Hour)
 			}
-			dur := time.Until(next)
 			log.Printf("⏳ 等待到下一个9:10: %s", next.Format("2006-01-02 15:04:05"))
-			time.Sleep(dur)
+			if !sleepUntil(ctx, next) {
+				return
+			}
 
 			// 汇总推送
-			stats, err := readDailyStats()
-			if err != nil {
-				log.Printf("❌ 读取脚本统计失败: %v", err)
-				continue
-			}
-			if stats.Total > 0 {
-				msg := fmt.Sprintf("📌【脚本统计】\n🔵 总执行: %d\n✅ 成功: %d\n❌ 失败: %d", stats.Total, stats.Success, stats.Fail)
-				if len(stats.Errors) > 0 {
-					msg += "\n\n🚫 错误信息:\n➖ " + strings.Join(stats.Errors, "\n➖ ")
-				}
-				if err := SendNotifyNowViaQL(cfg, "📥 每日脚本执行统计", msg); err != nil {
-					log.Printf("❌ 推送脚本统计失败: %v", err)
-				}
-			}
+			c.PushStatsOnce(ctx)
 			// 删除统计文件
 			//os.Remove(getStatsFile())
 			os.WriteFile(notifyCacheFile, []byte("[]"), 0644)
@@ -474,24 +352,25 @@ func StartStatsScheduler(cfg *utils.Config) {
 	}()
 }
 
-func StartNotifyScheduler(cfg *utils.Config) {
+func (c *Client) StartNotifyScheduler(ctx context.Context) {
 	go func() {
 		for {
 			now := time.Now()
 			// 计算下一个整点
 			next := now.Truncate(time.Hour).Add(time.Hour)
-			duration := time.Until(next)
 			log.Printf("⏳ 等待到下一个整点: %s", next.Format("15:04:05"))
-			time.Sleep(duration)
+			if !sleepUntil(ctx, next) {
+				return
+			}
 
-			if err := FlushNotifyBuffer(cfg); err != nil {
+			if err := c.FlushNotifyBuffer(ctx); err != nil {
 				log.Printf("❌ 通知缓冲发送失败: %v", err)
 			}
 		}
 	}()
 }
 
-func FlushNotifyBuffer(cfg *utils.Config) error {
+func (c *Client) FlushNotifyBuffer(ctx context.Context) error {
 	if _, err := os.Stat(notifyCacheFile); os.IsNotExist(err) {
 		log.Println("📭 无需发送通知（无缓存文件）")
 		return nil
@@ -522,12 +401,7 @@ func FlushNotifyBuffer(cfg *utils.Config) error {
 
 	// 发送一次合并消息
 	log.Println("📨 整点发送合并通知")
-	err = RunScriptContent(cfg, cfg.QL.Notify.ScriptFile, cfg.QL.Notify.ScriptPath,
-		RenderTemplate(cfg.QL.Notify.Template, map[string]string{
-			"title": "📥 每小时通知汇总",
-			"body":  body.String(),
-		}),
-	)
+	err = c.SendNotifyNow(ctx, "📥 每小时通知汇总", body.String())
 	if err != nil {
 		return err
 	}
@@ -535,4 +409,3 @@ func FlushNotifyBuffer(cfg *utils.Config) error {
 	// 清空缓存
 	return os.WriteFile(notifyCacheFile, []byte("[]"), 0644)
 }
-
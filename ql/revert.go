@@ -0,0 +1,142 @@
+package ql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DeleteAction 来源消息被删除后对其写入的变量的处理方式
+type DeleteAction string
+
+const (
+	DeleteNone    DeleteAction = "none"    // 不处理（默认）
+	DeleteRestore DeleteAction = "restore" // 按历史恢复为写入前的值，写入前不存在的变量会被禁用
+	DeleteDisable DeleteAction = "disable" // 禁用该消息写入的变量
+)
+
+// ParseDeleteAction 解析配置中的处理方式，空值返回 DeleteNone
+func ParseDeleteAction(s string) (DeleteAction, error) {
+	switch a := DeleteAction(strings.ToLower(strings.TrimSpace(s))); a {
+	case "":
+		return DeleteNone, nil
+	case DeleteNone, DeleteRestore, DeleteDisable:
+		return a, nil
+	default:
+		return DeleteNone, fmt.Errorf("未知的删除处理方式: %s", s)
+	}
+}
+
+// Revert 撤销某条来源消息在各实例写入的变量，返回每个变量的处理结果
+func (r *Router) Revert(ctx context.Context, peerID int64, messageID int, action DeleteAction) ([]string, error) {
+	if action == DeleteNone {
+		return nil, nil
+	}
+	var lines []string
+	var errs []error
+	for _, c := range r.clients {
+		ls, err := c.revertSource(ctx, peerID, messageID, action)
+		for _, l := range ls {
+			if len(r.clients) > 1 {
+				l = "[" + c.Name() + "] " + l
+			}
+			lines = append(lines, l)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", c.Name(), err))
+		}
+	}
+	return lines, errors.Join(errs...)
+}
+
+// revertSource 按历史找出来源消息写入的变量并撤销
+// 变量在之后又被其他来源更新过时保持不变，避免覆盖更新的值
+func (c *Client) revertSource(ctx context.Context, peerID int64, messageID int, action DeleteAction) ([]string, error) {
+	all, err := History("")
+	if err != nil {
+		return nil, fmt.Errorf("读取变量历史失败: %w", err)
+	}
+
+	const (
+		collecting  = iota + 1 // 最新的记录来自该消息
+		overwritten            // 最新的记录来自其他来源
+		done
+	)
+	state := make(map[string]int)
+	written := make(map[string][]HistoryEntry) // 最新在前
+	var names []string
+	var lines []string
+	for _, e := range all {
+		// 早期记录没有实例名，视为属于任意实例
+		if e.Instance != c.Name() && e.Instance != "" {
+			continue
+		}
+		fromSource := e.Source.PeerID == peerID && e.Source.MessageID == messageID
+		switch state[e.Name] {
+		case 0:
+			if fromSource {
+				state[e.Name] = collecting
+				names = append(names, e.Name)
+				written[e.Name] = append(written[e.Name], e)
+			} else {
+				state[e.Name] = overwritten
+			}
+		case collecting:
+			if fromSource {
+				written[e.Name] = append(written[e.Name], e)
+			} else {
+				state[e.Name] = done
+			}
+		case overwritten:
+			if fromSource {
+				state[e.Name] = done
+				lines = append(lines, fmt.Sprintf("%s: 之后已被其他消息更新，保持不变", e.Name))
+			}
+		}
+	}
+
+	src := Source{PeerID: peerID, Peer: fmt.Sprintf("删除消息 #%d", messageID)}
+	for _, name := range names {
+		entries := written[name]
+		switch action {
+		case DeleteRestore:
+			// 从最新到最早依次恢复，最终得到该消息第一次写入前的值
+			for _, e := range entries {
+				if err := c.restore(ctx, e, src); err != nil {
+					return lines, fmt.Errorf("恢复变量 %s 失败: %w", name, err)
+				}
+			}
+			if oldest := entries[len(entries)-1]; oldest.Created {
+				lines = append(lines, fmt.Sprintf("%s: 写入前不存在，已禁用", name))
+			} else {
+				lines = append(lines, fmt.Sprintf("%s: 已恢复为 %s", name, oldest.Old))
+			}
+		case DeleteDisable:
+			existing, err := c.searchEnvs(ctx, name)
+			if err != nil {
+				return lines, fmt.Errorf("查询变量 %s 失败: %w", name, err)
+			}
+			// 该消息的每次写入都可能对应不同条目（如 all 策略或先新增后编辑），按 ID 去重后一起禁用
+			var envs []Env
+			seen := make(map[int64]bool)
+			for _, e := range entries {
+				for _, env := range writtenEnvs(existing, e) {
+					if env.ID != nil && !seen[*env.ID] {
+						seen[*env.ID] = true
+						envs = append(envs, env)
+					}
+				}
+			}
+			if len(envs) == 0 {
+				lines = append(lines, fmt.Sprintf("%s: 青龙中已找不到该消息写入的条目", name))
+				continue
+			}
+			if err := c.disableEnvs(ctx, envs); err != nil {
+				return lines, fmt.Errorf("禁用变量 %s 失败: %w", name, err)
+			}
+			lines = append(lines, fmt.Sprintf("%s: 已禁用 %d 个条目", name, len(envs)))
+		}
+	}
+	return lines, nil
+}
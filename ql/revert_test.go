@@ -0,0 +1,137 @@
+package ql
+
+import (
+	"reflect"
+	"testing"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestRevertSource(t *testing.T) {
+	const peer = 1
+	type write struct {
+		msg   int
+		value string
+	}
+	tests := []struct {
+		name    string
+		seed    []Env
+		writes  []write
+		after   func(f *fakeQL)
+		action  DeleteAction
+		want    []string // 撤销后青龙中的 “ID:值/状态”
+		lines   int
+		wantErr bool
+	}{
+		{
+			name:   "之后被其他消息覆盖时保持不变",
+			writes: []write{{1, "a"}, {2, "b"}},
+			action: DeleteRestore,
+			want:   []string{"100:b/0"},
+			lines:  1,
+		},
+		{
+			name:   "覆盖其他消息的值后恢复",
+			writes: []write{{2, "a"}, {1, "b"}},
+			action: DeleteRestore,
+			want:   []string{"100:a/0"},
+			lines:  1,
+		},
+		{
+			name:   "编辑后删除恢复为第一次写入前的值",
+			seed:   []Env{{ID: envID(1), Name: "jd_test", Value: "x"}},
+			writes: []write{{1, "a"}, {1, "b"}},
+			action: DeleteRestore,
+			want:   []string{"1:x/0"},
+			lines:  1,
+		},
+		{
+			name:   "新增后删除",
+			writes: []write{{1, "a"}},
+			action: DeleteRestore,
+			want:   []string{"100:a/1"},
+			lines:  1,
+		},
+		{
+			name:   "新增并编辑后删除",
+			writes: []write{{1, "a"}, {1, "b"}},
+			action: DeleteRestore,
+			want:   []string{"100:a/1"},
+			lines:  1,
+		},
+		{
+			name:   "禁用",
+			seed:   []Env{{ID: envID(1), Name: "jd_test", Value: "x"}, {ID: envID(2), Name: "other", Value: "y"}},
+			writes: []write{{1, "a"}},
+			action: DeleteDisable,
+			want:   []string{"1:a/1", "2:y/0"},
+			lines:  1,
+		},
+		{
+			name:   "禁用编辑过的新增变量",
+			writes: []write{{1, "a"}, {1, "b"}},
+			action: DeleteDisable,
+			want:   []string{"100:b/1"},
+			lines:  1,
+		},
+		{
+			name:   "之后被其他消息覆盖时不禁用",
+			writes: []write{{1, "a"}, {2, "b"}},
+			action: DeleteDisable,
+			want:   []string{"100:b/0"},
+			lines:  1,
+		},
+		{
+			name:   "写入的条目已删除时不改动其他同名变量",
+			seed:   []Env{{ID: envID(1), Name: "jd_test", Value: "x"}},
+			writes: []write{{1, "a"}},
+			after: func(f *fakeQL) {
+				f.delete(1)
+				f.envs = append(f.envs, Env{ID: envID(2), Name: "jd_test", Value: "z"})
+			},
+			action:  DeleteRestore,
+			want:    []string{"2:z/0"},
+			wantErr: true,
+		},
+		{
+			name:   "不处理",
+			writes: []write{{1, "a"}},
+			action: DeleteNone,
+			want:   []string{"100:a/0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			tokens = &tokenManager{}
+
+			f := newFakeQL(tt.seed...)
+			r, err := NewRouter(&utils.Config{QL: utils.QLInstances{{Name: "main", BaseURL: "http://ql.test"}}},
+				WithTransport(f), WithRetries(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := r.Primary()
+			for _, w := range tt.writes {
+				src := Source{PeerID: peer, MessageID: w.msg}
+				if _, err := c.UpdateEnvNames(t.Context(), []string{"jd_test"}, w.value, src); err != nil {
+					t.Fatalf("写入 %s 失败: %v", w.value, err)
+				}
+			}
+			if tt.after != nil {
+				tt.after(f)
+			}
+
+			lines, err := r.Revert(t.Context(), peer, 1, tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v，期望出错: %v", err, tt.wantErr)
+			}
+			if len(lines) != tt.lines {
+				t.Errorf("lines = %q，期望 %d 行", lines, tt.lines)
+			}
+			if got := f.state(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("青龙变量 = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
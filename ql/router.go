@@ -0,0 +1,145 @@
+package ql
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"telegram-env-watcher/utils"
+)
+
+// Router 管理多个青龙实例，按变量名和来源决定写入哪些实例
+type Router struct {
+	clients []*Client
+	byName  map[string]*Client
+	routes  []route
+}
+
+type route struct {
+	vars      []string
+	sources   []string
+	instances []*Client
+}
+
+// NewRouter 根据配置创建全部实例客户端并编译路由规则
+func NewRouter(cfg *utils.Config, opts ...Option) (*Router, error) {
+	if len(cfg.QL) == 0 {
+		return nil, fmt.Errorf("未配置任何青龙实例")
+	}
+	r := &Router{byName: make(map[string]*Client)}
+	for _, qc := range cfg.QL {
+		c := NewClient(qc, opts...)
+		if _, dup := r.byName[c.Name()]; dup {
+			return nil, fmt.Errorf("青龙实例名称重复: %s", c.Name())
+		}
+		r.byName[c.Name()] = c
+		r.clients = append(r.clients, c)
+	}
+	for _, c := range r.clients {
+		c.notifier = r.Primary()
+	}
+
+	for i, rc := range cfg.Routes {
+		rt := route{vars: rc.Vars}
+		for _, s := range rc.Sources {
+			rt.sources = append(rt.sources, strings.ToLower(strings.TrimPrefix(s, "@")))
+		}
+		for _, name := range rc.Instances {
+			c, ok := r.byName[name]
+			if !ok {
+				return nil, fmt.Errorf("路由规则 #%d 引用了不存在的青龙实例: %s", i+1, name)
+			}
+			rt.instances = append(rt.instances, c)
+		}
+		if len(rt.instances) == 0 {
+			return nil, fmt.Errorf("路由规则 #%d 没有指定目标实例", i+1)
+		}
+		r.routes = append(r.routes, rt)
+	}
+	return r, nil
+}
+
+// Clients 返回全部实例
+func (r *Router) Clients() []*Client {
+	return r.clients
+}
+
+// Client 按名称查找实例
+func (r *Router) Client(name string) (*Client, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// Primary 返回第一个实例，用于发送通知和统计
+func (r *Router) Primary() *Client {
+	return r.clients[0]
+}
+
+// Rollback 回滚变量，instance 为空时使用该变量最近一次写入的实例
+func (r *Router) Rollback(ctx context.Context, name, instance string, steps int) (*HistoryEntry, error) {
+	if instance == "" {
+		entries, err := History(name)
+		if err != nil {
+			return nil, fmt.Errorf("读取变量历史失败: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("变量 %s 没有历史记录", name)
+		}
+		instance = entries[0].Instance
+	}
+	if instance == "" {
+		instance = r.Primary().Name()
+	}
+	c, ok := r.Client(instance)
+	if !ok {
+		return nil, fmt.Errorf("青龙实例不存在: %s", instance)
+	}
+	return c.Rollback(ctx, name, steps)
+}
+
+// Route 返回变量应写入的实例，所有命中规则的目标取并集，均未命中时返回全部实例
+func (r *Router) Route(name string, src Source) []*Client {
+	var out []*Client
+	seen := make(map[*Client]bool)
+	for _, rt := range r.routes {
+		if !rt.match(name, src) {
+			continue
+		}
+		for _, c := range rt.instances {
+			if !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return r.clients
+	}
+	return out
+}
+
+func (rt route) match(name string, src Source) bool {
+	if len(rt.vars) > 0 {
+		ok := false
+		for _, p := range rt.vars {
+			if m, _ := path.Match(p, name); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(rt.sources) > 0 {
+		username := strings.ToLower(src.Username)
+		for _, s := range rt.sources {
+			if s == username {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package ql
+
+import (
+	"net/http"
+	"testing"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestNotifyFallsBackToPrimary(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tokens = &tokenManager{}
+
+	cfg := &utils.Config{QL: utils.QLInstances{
+		{Name: "main", BaseURL: "http://main.test", ClientID: "id"},
+		{Name: "backup", BaseURL: "http://backup.test", ClientID: "id"},
+	}}
+	cfg.QL[0].Notify.ScriptFile = "notify.js"
+	cfg.QL[0].Notify.Template = "{{title}}: {{body}}"
+
+	rt := &stubTransport{status: http.StatusOK, calls: make(map[string]int)}
+	r, err := NewRouter(cfg, WithTransport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, _ := r.Client("backup")
+	if err := backup.SendNotifyNow(t.Context(), "❌ 脚本运行失败", "jd_test"); err != nil {
+		t.Fatalf("未配置通知脚本的实例应由主实例发送: %v", err)
+	}
+	if got := rt.count("PUT /open/scripts/run"); got != 1 {
+		t.Errorf("通知脚本运行次数 = %d，期望 1", got)
+	}
+
+	// 只有一个实例且未配置通知脚本时返回错误
+	solo := NewClient(utils.QLConfig{Name: "solo", BaseURL: "http://solo.test"}, WithTransport(rt))
+	if err := solo.SendNotifyNow(t.Context(), "t", "b"); err == nil {
+		t.Error("期望返回未配置通知脚本的错误")
+	}
+}
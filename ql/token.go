@@ -0,0 +1,190 @@
+package ql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var tokenCacheFile = "./ql_token.json"
+
+const (
+	// 距离过期不足该时长时提前刷新
+	tokenRefreshAhead = 5 * time.Minute
+	// 青龙未返回 expiration 时的保守有效期
+	tokenDefaultTTL = 24 * time.Hour
+)
+
+type tokenResp struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Token      string `json:"token"`
+		TokenType  string `json:"token_type"`
+		Expiration int64  `json:"expiration"` // Unix 时间戳（秒）
+	} `json:"data"`
+}
+
+type cachedToken struct {
+	Token      string `json:"token"`
+	Expiration int64  `json:"expiration"`
+}
+
+func (t cachedToken) valid(now time.Time) bool {
+	return t.Token != "" && now.Add(tokenRefreshAhead).Before(time.Unix(t.Expiration, 0))
+}
+
+// tokenManager 缓存青龙 token，过期前自动刷新，并发安全
+// mu 只保护缓存和文件读写；登录请求按实例加锁，一个实例卡住不影响其他实例取 token
+type tokenManager struct {
+	mu       sync.Mutex
+	loaded   bool
+	tokens   map[string]cachedToken // key: base_url|client_id
+	fetching map[string]*sync.Mutex // 每个 key 同时只有一个登录请求
+}
+
+var tokens = &tokenManager{}
+
+func (c *Client) tokenKey() string {
+	return c.cfg.BaseURL + "|" + c.cfg.ClientID
+}
+
+// load 从磁盘读取缓存的 token，只在首次使用时执行
+func (m *tokenManager) load() {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+	m.tokens = make(map[string]cachedToken)
+	data, err := os.ReadFile(tokenCacheFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取 token 缓存失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &m.tokens); err != nil {
+		log.Printf("⚠️ 解析 token 缓存失败: %v", err)
+		m.tokens = make(map[string]cachedToken)
+	}
+}
+
+func (m *tokenManager) save() {
+	data, err := json.MarshalIndent(m.tokens, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(tokenCacheFile, data, 0600); err != nil {
+		log.Printf("⚠️ 保存 token 缓存失败: %v", err)
+	}
+}
+
+// get 返回可用 token，缓存缺失或即将过期时调用 fetch 重新登录
+// 同一实例的并发调用等待同一次登录完成后直接使用其结果
+func (m *tokenManager) get(key string, fetch func() (cachedToken, error)) (string, error) {
+	if t, ok := m.cached(key); ok {
+		return t, nil
+	}
+
+	lock := m.fetchLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	// 等待期间其他请求可能已经完成登录
+	if t, ok := m.cached(key); ok {
+		return t, nil
+	}
+
+	t, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[key] = t
+	m.save()
+	return t.Token, nil
+}
+
+// cached 返回仍然有效的缓存 token
+func (m *tokenManager) cached(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	if t, ok := m.tokens[key]; ok && t.valid(time.Now()) {
+		return t.Token, true
+	}
+	return "", false
+}
+
+func (m *tokenManager) fetchLock(key string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fetching == nil {
+		m.fetching = make(map[string]*sync.Mutex)
+	}
+	l, ok := m.fetching[key]
+	if !ok {
+		l = &sync.Mutex{}
+		m.fetching[key] = l
+	}
+	return l
+}
+
+// invalidate 丢弃已失效的 token；若缓存已被其他请求刷新则保持不变
+func (m *tokenManager) invalidate(key, token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	if t, ok := m.tokens[key]; ok && t.Token == token {
+		delete(m.tokens, key)
+		m.save()
+	}
+}
+
+func (c *Client) fetchToken(ctx context.Context) (cachedToken, error) {
+	url := fmt.Sprintf("%s/open/auth/token?client_id=%s&client_secret=%s",
+		c.cfg.BaseURL, c.cfg.ClientID, c.cfg.ClientSecret)
+	if c.debug {
+		log.Printf("🔗 请求地址: %s\n", url)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	const path = "/open/auth/token"
+	if resp.StatusCode >= 300 {
+		return cachedToken{}, statusError("GET", path, resp.StatusCode, body)
+	}
+	var r tokenResp
+	if err := json.Unmarshal(body, &r); err != nil {
+		return cachedToken{}, decodeError("GET", path, body, err)
+	}
+	if r.Code != 200 || r.Data.Token == "" {
+		return cachedToken{}, &APIError{Kind: ErrAuth, Method: "GET", Path: path, Status: r.Code, Body: r.Message}
+	}
+
+	t := cachedToken{Token: r.Data.Token, Expiration: r.Data.Expiration}
+	if t.Expiration == 0 {
+		t.Expiration = time.Now().Add(tokenDefaultTTL).Unix()
+	}
+	if c.debug {
+		log.Printf("🔑 获取新 token，过期时间: %s", time.Unix(t.Expiration, 0).Format("2006-01-02 15:04:05"))
+	}
+	return t, nil
+}
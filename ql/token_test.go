@@ -0,0 +1,58 @@
+package ql
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenManagerFetchPerKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := &tokenManager{}
+	valid := func(tok string) cachedToken {
+		return cachedToken{Token: tok, Expiration: time.Now().Add(time.Hour).Unix()}
+	}
+
+	// 实例 a 的登录请求卡住
+	started, release := make(chan struct{}), make(chan struct{})
+	var fetches atomic.Int32
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := m.get("a", func() (cachedToken, error) {
+				if fetches.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return valid("ta"), nil
+			})
+			if err != nil || tok != "ta" {
+				t.Errorf("get(a) = %q, %v", tok, err)
+			}
+		}()
+	}
+	<-started
+
+	// 其他实例不受影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if tok, err := m.get("b", func() (cachedToken, error) { return valid("tb"), nil }); err != nil || tok != "tb" {
+			t.Errorf("get(b) = %q, %v", tok, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("实例 a 登录期间实例 b 取 token 被阻塞")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("实例 a 登录 %d 次，期望 1 次", n)
+	}
+}
@@ -0,0 +1,449 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// grid 采样得到的模块矩阵，true 为深色
+type grid struct {
+	size int
+	bits []bool
+}
+
+func newGrid(size int) *grid {
+	return &grid{size: size, bits: make([]bool, size*size)}
+}
+
+func (g *grid) get(row, col int) bool { return g.bits[row*g.size+col] }
+
+func (g *grid) set(row, col int, v bool) { g.bits[row*g.size+col] = v }
+
+// readBits 按给定坐标顺序读取若干位，先读到的为高位
+func (g *grid) readBits(coords [][2]int) int {
+	v := 0
+	for _, c := range coords {
+		v <<= 1
+		if g.get(c[0], c[1]) {
+			v |= 1
+		}
+	}
+	return v
+}
+
+func (g *grid) format() (level, mask int, ok bool) {
+	n := g.size
+	var c1, c2 [][2]int
+	for col := 0; col <= 5; col++ {
+		c1 = append(c1, [2]int{8, col})
+	}
+	c1 = append(c1, [2]int{8, 7}, [2]int{8, 8}, [2]int{7, 8})
+	for row := 5; row >= 0; row-- {
+		c1 = append(c1, [2]int{row, 8})
+	}
+	for row := n - 1; row >= n-7; row-- {
+		c2 = append(c2, [2]int{row, 8})
+	}
+	for col := n - 8; col < n; col++ {
+		c2 = append(c2, [2]int{8, col})
+	}
+	return decodeFormat(g.readBits(c1), g.readBits(c2))
+}
+
+func (g *grid) version() (int, bool) {
+	n := g.size
+	var c1, c2 [][2]int
+	for row := 5; row >= 0; row-- {
+		for col := n - 9; col >= n-11; col-- {
+			c1 = append(c1, [2]int{row, col})
+		}
+	}
+	for col := 5; col >= 0; col-- {
+		for row := n - 9; row >= n-11; row-- {
+			c2 = append(c2, [2]int{row, col})
+		}
+	}
+	return decodeVersion(g.readBits(c1), g.readBits(c2))
+}
+
+// functionMask 标记定位、校正、定时、格式和版本信息等非数据区域
+func functionMask(version int) *grid {
+	n := version*4 + 17
+	m := newGrid(n)
+	fill := func(row, col, h, w int) {
+		for r := row; r < row+h; r++ {
+			for c := col; c < col+w; c++ {
+				if r >= 0 && r < n && c >= 0 && c < n {
+					m.set(r, c, true)
+				}
+			}
+		}
+	}
+	fill(0, 0, 9, 9)
+	fill(0, n-8, 9, 8)
+	fill(n-8, 0, 8, 9)
+	fill(6, 0, 1, n)
+	fill(0, 6, n, 1)
+	pos := alignmentPositions(version)
+	for i, r := range pos {
+		for j, c := range pos {
+			// 与定位图形重叠的三个位置没有校正图形
+			if (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0) {
+				continue
+			}
+			fill(r-2, c-2, 5, 5)
+		}
+	}
+	if version >= 7 {
+		fill(0, n-11, 6, 3)
+		fill(n-11, 0, 3, 6)
+	}
+	return m
+}
+
+func maskBit(mask, row, col int) bool {
+	switch mask {
+	case 0:
+		return (row+col)%2 == 0
+	case 1:
+		return row%2 == 0
+	case 2:
+		return col%3 == 0
+	case 3:
+		return (row+col)%3 == 0
+	case 4:
+		return (row/2+col/3)%2 == 0
+	case 5:
+		return row*col%2+row*col%3 == 0
+	case 6:
+		return (row*col%2+row*col%3)%2 == 0
+	default:
+		return ((row+col)%2+row*col%3)%2 == 0
+	}
+}
+
+// codewords 按之字形顺序读取并去掉掩码
+func (g *grid) codewords(version, mask int) []byte {
+	n := g.size
+	fn := functionMask(version)
+	total := rawCodewords(version)
+	out := make([]byte, 0, total)
+	var cur byte
+	bits := 0
+	up := true
+	for right := n - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < n; i++ {
+			row := i
+			if up {
+				row = n - 1 - i
+			}
+			for c := 0; c < 2; c++ {
+				col := right - c
+				if fn.get(row, col) {
+					continue
+				}
+				cur <<= 1
+				if g.get(row, col) != maskBit(mask, row, col) {
+					cur |= 1
+				}
+				if bits++; bits == 8 {
+					if len(out) < total {
+						out = append(out, cur)
+					}
+					cur, bits = 0, 0
+				}
+			}
+		}
+		up = !up
+	}
+	return out
+}
+
+// correct 拆分交织的分块并逐块纠错，返回数据码字
+func correct(raw []byte, version, level int) ([]byte, error) {
+	numBlocks := ecBlocks[level][version]
+	ecLen := ecCodewordsPerBlock[level][version]
+	total := rawCodewords(version)
+	if len(raw) != total {
+		return nil, fmt.Errorf("码字数量不符: %d != %d", len(raw), total)
+	}
+	numShort := numBlocks - total%numBlocks
+	shortData := total/numBlocks - ecLen
+
+	blocks := make([][]byte, numBlocks)
+	dataLen := func(b int) int {
+		if b < numShort {
+			return shortData
+		}
+		return shortData + 1
+	}
+	for b := range blocks {
+		blocks[b] = make([]byte, dataLen(b)+ecLen)
+	}
+	pos := 0
+	for i := 0; i <= shortData; i++ {
+		for b := range blocks {
+			if i < dataLen(b) {
+				blocks[b][i] = raw[pos]
+				pos++
+			}
+		}
+	}
+	for i := 0; i < ecLen; i++ {
+		for b := range blocks {
+			blocks[b][dataLen(b)+i] = raw[pos]
+			pos++
+		}
+	}
+
+	var data []byte
+	for b, block := range blocks {
+		if err := rsCorrect(block, ecLen); err != nil {
+			return nil, err
+		}
+		data = append(data, block[:dataLen(b)]...)
+	}
+	return data, nil
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) available() int { return len(r.data)*8 - r.pos }
+
+func (r *bitReader) read(n int) (int, error) {
+	if n > r.available() {
+		return 0, errTruncated
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0 {
+			v |= 1
+		}
+		r.pos++
+	}
+	return v, nil
+}
+
+var errTruncated = errors.New("数据不完整")
+
+const alnumChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// ECI 字符集编号
+const (
+	eciLatin1   = 3
+	eciShiftJIS = 20
+	eciUTF8     = 26
+)
+
+// decodeData 解析数据码字中的各个分段
+func decodeData(data []byte, version int) (string, error) {
+	r := &bitReader{data: data}
+	var b strings.Builder
+	eci := -1
+
+	countBits := func(mode int) int {
+		idx := 0
+		if version >= 27 {
+			idx = 2
+		} else if version >= 10 {
+			idx = 1
+		}
+		switch mode {
+		case 1:
+			return [3]int{10, 12, 14}[idx]
+		case 2:
+			return [3]int{9, 11, 13}[idx]
+		case 4:
+			return [3]int{8, 16, 16}[idx]
+		default:
+			return [3]int{8, 10, 12}[idx]
+		}
+	}
+
+	for r.available() >= 4 {
+		mode, _ := r.read(4)
+		switch mode {
+		case 0: // 终止符
+			return b.String(), nil
+		case 3: // 结构化链接，跳过序号和校验
+			if _, err := r.read(16); err != nil {
+				return "", err
+			}
+		case 5: // FNC1 第一位置
+		case 9: // FNC1 第二位置
+			if _, err := r.read(8); err != nil {
+				return "", err
+			}
+		case 7:
+			v, err := readECI(r)
+			if err != nil {
+				return "", err
+			}
+			eci = v
+		case 1, 2, 4, 8:
+			count, err := r.read(countBits(mode))
+			if err != nil {
+				return "", err
+			}
+			var seg string
+			switch mode {
+			case 1:
+				seg, err = readNumeric(r, count)
+			case 2:
+				seg, err = readAlnum(r, count)
+			case 4:
+				seg, err = readBytes(r, count, eci)
+			case 8:
+				seg, err = readKanji(r, count)
+			}
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(seg)
+		default:
+			return "", fmt.Errorf("未知的数据模式: %d", mode)
+		}
+	}
+	return b.String(), nil
+}
+
+func readECI(r *bitReader) (int, error) {
+	first, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case first&0x80 == 0:
+		return first & 0x7f, nil
+	case first&0xc0 == 0x80:
+		next, err := r.read(8)
+		return (first&0x3f)<<8 | next, err
+	case first&0xe0 == 0xc0:
+		next, err := r.read(16)
+		return (first&0x1f)<<16 | next, err
+	default:
+		return 0, fmt.Errorf("无效的 ECI")
+	}
+}
+
+func readNumeric(r *bitReader, count int) (string, error) {
+	var b strings.Builder
+	for count > 0 {
+		n, bits := 3, 10
+		if count == 2 {
+			n, bits = 2, 7
+		} else if count == 1 {
+			n, bits = 1, 4
+		}
+		v, err := r.read(bits)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%0*d", n, v)
+		count -= n
+	}
+	return b.String(), nil
+}
+
+func readAlnum(r *bitReader, count int) (string, error) {
+	var b strings.Builder
+	for count > 1 {
+		v, err := r.read(11)
+		if err != nil {
+			return "", err
+		}
+		if v >= 45*45 {
+			return "", fmt.Errorf("无效的字母数字编码")
+		}
+		b.WriteByte(alnumChars[v/45])
+		b.WriteByte(alnumChars[v%45])
+		count -= 2
+	}
+	if count == 1 {
+		v, err := r.read(6)
+		if err != nil {
+			return "", err
+		}
+		if v >= 45 {
+			return "", fmt.Errorf("无效的字母数字编码")
+		}
+		b.WriteByte(alnumChars[v])
+	}
+	return b.String(), nil
+}
+
+// readBytes 读取字节分段；未声明 ECI 时合法 UTF-8 按 UTF-8 处理，否则按 ISO-8859-1
+func readBytes(r *bitReader, count, eci int) (string, error) {
+	buf := make([]byte, count)
+	for i := range buf {
+		v, err := r.read(8)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = byte(v)
+	}
+	switch {
+	case eci == eciUTF8 || (eci < 0 && utf8.Valid(buf)):
+		return string(buf), nil
+	case eci == eciShiftJIS:
+		return japanese.ShiftJIS.NewDecoder().String(string(buf))
+	default:
+		runes := make([]rune, len(buf))
+		for i, c := range buf {
+			runes[i] = rune(c)
+		}
+		return string(runes), nil
+	}
+}
+
+func readKanji(r *bitReader, count int) (string, error) {
+	buf := make([]byte, 0, count*2)
+	for i := 0; i < count; i++ {
+		v, err := r.read(13)
+		if err != nil {
+			return "", err
+		}
+		c := v/0xc0<<8 | v%0xc0
+		if c < 0x1f00 {
+			c += 0x8140
+		} else {
+			c += 0xc140
+		}
+		buf = append(buf, byte(c>>8), byte(c))
+	}
+	return japanese.ShiftJIS.NewDecoder().String(string(buf))
+}
+
+// decodeGrid 从采样矩阵解出文本
+func decodeGrid(g *grid) (string, error) {
+	level, mask, ok := g.format()
+	if !ok {
+		return "", errors.New("格式信息无效")
+	}
+	version := (g.size - 17) / 4
+	if version >= 7 {
+		v, ok := g.version()
+		if !ok {
+			return "", errors.New("版本信息无效")
+		}
+		if v != version {
+			return "", fmt.Errorf("版本与尺寸不符: %d", v)
+		}
+	}
+	data, err := correct(g.codewords(version, mask), version, level)
+	if err != nil {
+		return "", err
+	}
+	return decodeData(data, version)
+}
@@ -0,0 +1,618 @@
+package qrcode
+
+import (
+	"math"
+	"sort"
+)
+
+// bitmap 二值化后的图像，true 为深色
+type bitmap struct {
+	w, h int
+	bits []bool
+}
+
+func (b *bitmap) get(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.w || y >= b.h {
+		return false
+	}
+	return b.bits[y*b.w+x]
+}
+
+type point struct{ x, y float64 }
+
+func dist(a, b point) float64 { return math.Hypot(a.x-b.x, a.y-b.y) }
+
+// finder 定位图形候选，module 为估计的模块边长（像素）
+type finder struct {
+	point
+	module float64
+	count  int
+}
+
+// patternRatio 判断五段游程是否符合 1:1:3:1:1
+func patternRatio(sc [5]int) bool {
+	total := 0
+	for _, c := range sc {
+		if c == 0 {
+			return false
+		}
+		total += c
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	v := m / 2
+	return math.Abs(m-float64(sc[0])) < v &&
+		math.Abs(m-float64(sc[1])) < v &&
+		math.Abs(3*m-float64(sc[2])) < 3*v &&
+		math.Abs(m-float64(sc[3])) < v &&
+		math.Abs(m-float64(sc[4])) < v
+}
+
+func sum5(sc [5]int) int { return sc[0] + sc[1] + sc[2] + sc[3] + sc[4] }
+
+// crossCheck 从 (x, y) 沿 (dx, dy) 两个方向统计游程，符合 1:1:3:1:1 且总长与 origTotal 接近时返回总长
+func (b *bitmap) crossCheck(x, y, dx, dy, maxCount, origTotal int) (int, bool) {
+	var sc [5]int
+	at := func(i int) (int, int) { return x + i*dx, y + i*dy }
+
+	i := 0
+	for b.get(at(i)) {
+		sc[2]++
+		i--
+	}
+	for b.inside(at(i)) && !b.get(at(i)) && sc[1] <= maxCount {
+		sc[1]++
+		i--
+	}
+	if !b.inside(at(i)) || sc[1] > maxCount {
+		return 0, false
+	}
+	for b.get(at(i)) && sc[0] <= maxCount {
+		sc[0]++
+		i--
+	}
+	if sc[0] > maxCount {
+		return 0, false
+	}
+
+	i = 1
+	for b.get(at(i)) {
+		sc[2]++
+		i++
+	}
+	for b.inside(at(i)) && !b.get(at(i)) && sc[3] < maxCount {
+		sc[3]++
+		i++
+	}
+	if !b.inside(at(i)) || sc[3] >= maxCount {
+		return 0, false
+	}
+	for b.get(at(i)) && sc[4] < maxCount {
+		sc[4]++
+		i++
+	}
+	if sc[4] >= maxCount {
+		return 0, false
+	}
+
+	total := sum5(sc)
+	if 5*abs(total-origTotal) >= 2*origTotal || !patternRatio(sc) {
+		return 0, false
+	}
+	return total, true
+}
+
+func (b *bitmap) inside(x, y int) bool {
+	return x >= 0 && y >= 0 && x < b.w && y < b.h
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// findFinders 逐行扫描，查找并交叉验证定位图形
+func (b *bitmap) findFinders() []*finder {
+	var found []*finder
+	add := func(c point, module float64) {
+		for _, f := range found {
+			if math.Abs(f.x-c.x) <= module && math.Abs(f.y-c.y) <= module &&
+				math.Abs(f.module-module) <= math.Max(1, f.module/2) {
+				n := float64(f.count)
+				f.x = (f.x*n + c.x) / (n + 1)
+				f.y = (f.y*n + c.y) / (n + 1)
+				f.module = (f.module*n + module) / (n + 1)
+				f.count++
+				return
+			}
+		}
+		found = append(found, &finder{point: c, module: module, count: 1})
+	}
+
+	check := func(sc [5]int, endX, y int) {
+		total := sum5(sc)
+		cx := int(float64(endX) - float64(sc[4]) - float64(sc[3]) - float64(sc[2])/2)
+		vTotal, ok := b.crossCheck(cx, y, 0, 1, sc[2], total)
+		if !ok {
+			return
+		}
+		cy := b.verticalCenter(cx, y)
+		hTotal, ok := b.crossCheck(cx, int(cy), 1, 0, sc[2], total)
+		if !ok {
+			return
+		}
+		add(point{b.horizontalCenter(cx, int(cy)), cy}, float64(vTotal+hTotal)/14)
+	}
+
+	for y := 0; y < b.h; y++ {
+		var sc [5]int
+		state := 0
+		for x := 0; x < b.w; x++ {
+			dark := b.get(x, y)
+			if dark {
+				if state&1 == 1 {
+					state++
+				}
+				sc[state]++
+				continue
+			}
+			if state&1 == 1 {
+				sc[state]++
+				continue
+			}
+			if state == 0 && sc[0] == 0 {
+				continue
+			}
+			if state == 4 {
+				if patternRatio(sc) {
+					check(sc, x, y)
+				}
+				sc = [5]int{sc[2], sc[3], sc[4], 1, 0}
+				state = 3
+				continue
+			}
+			state++
+			sc[state]++
+		}
+		if state == 4 && patternRatio(sc) {
+			check(sc, b.w, y)
+		}
+	}
+	return found
+}
+
+// verticalCenter 返回 x 列上包含 y 的深色游程的中点
+func (b *bitmap) verticalCenter(x, y int) float64 {
+	top, bottom := y, y
+	for b.get(x, top-1) {
+		top--
+	}
+	for b.get(x, bottom+1) {
+		bottom++
+	}
+	return float64(top+bottom+1) / 2
+}
+
+// horizontalCenter 返回 y 行上包含 x 的深色游程的中点
+func (b *bitmap) horizontalCenter(x, y int) float64 {
+	left, right := x, x
+	for b.get(left-1, y) {
+		left--
+	}
+	for b.get(right+1, y) {
+		right++
+	}
+	return float64(left+right+1) / 2
+}
+
+// triple 三个定位图形，按左上、右上、左下排列
+type triple struct {
+	tl, tr, bl *finder
+	score      float64
+}
+
+// candidateTriples 在候选定位图形中挑选近似等腰直角三角形的组合，按吻合程度排序
+func candidateTriples(finders []*finder) []triple {
+	sort.Slice(finders, func(i, j int) bool { return finders[i].count > finders[j].count })
+	if len(finders) > 12 {
+		finders = finders[:12]
+	}
+	var out []triple
+	for i := 0; i < len(finders); i++ {
+		for j := i + 1; j < len(finders); j++ {
+			for k := j + 1; k < len(finders); k++ {
+				if t, ok := orderTriple(finders[i], finders[j], finders[k]); ok {
+					out = append(out, t)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].score < out[j].score })
+	return out
+}
+
+func orderTriple(a, b, c *finder) (triple, bool) {
+	// 最长边对面的顶点为左上角
+	ab, bc, ac := dist(a.point, b.point), dist(b.point, c.point), dist(a.point, c.point)
+	var tl, p, q *finder
+	var hyp, s1, s2 float64
+	switch {
+	case bc >= ab && bc >= ac:
+		tl, p, q, hyp, s1, s2 = a, b, c, bc, ab, ac
+	case ac >= ab && ac >= bc:
+		tl, p, q, hyp, s1, s2 = b, a, c, ac, ab, bc
+	default:
+		tl, p, q, hyp, s1, s2 = c, a, b, ab, ac, bc
+	}
+
+	mods := []float64{a.module, b.module, c.module}
+	sort.Float64s(mods)
+	if mods[2] > mods[0]*2 {
+		return triple{}, false
+	}
+	if math.Min(s1, s2)/math.Max(s1, s2) < 0.7 {
+		return triple{}, false
+	}
+	expect := math.Hypot(s1, s2)
+	if math.Abs(hyp-expect)/expect > 0.15 {
+		return triple{}, false
+	}
+	// 中心距离至少为 14 个模块（版本 1），最多 170 个模块（版本 40）
+	module := (mods[0] + mods[1] + mods[2]) / 3
+	if s1/module < 10 || s1/module > 180 {
+		return triple{}, false
+	}
+
+	// 图像坐标 y 轴向下，右上 × 左下的叉积为正
+	cross := (p.x-tl.x)*(q.y-tl.y) - (p.y-tl.y)*(q.x-tl.x)
+	tr, bl := p, q
+	if cross < 0 {
+		tr, bl = q, p
+	}
+	score := math.Abs(s1-s2)/math.Max(s1, s2) + math.Abs(hyp-expect)/expect + (mods[2]-mods[0])/mods[2]
+	return triple{tl: tl, tr: tr, bl: bl, score: score}, true
+}
+
+// dimension 根据定位图形间距估计边长（模块数），结果满足 4n+1
+// 模块边长沿两个定位图形的连线测量，避免旋转时行扫描得到的宽度偏大
+func (b *bitmap) dimension(t triple) (int, float64) {
+	modTR := (b.moduleAlong(t.tl.point, t.tr.point) + b.moduleAlong(t.tr.point, t.tl.point)) / 2
+	modBL := (b.moduleAlong(t.tl.point, t.bl.point) + b.moduleAlong(t.bl.point, t.tl.point)) / 2
+	if modTR <= 0 || modBL <= 0 {
+		modTR = (t.tl.module + t.tr.module + t.bl.module) / 3
+		modBL = modTR
+	}
+	d := (dist(t.tl.point, t.tr.point)/modTR+dist(t.tl.point, t.bl.point)/modBL)/2 + 7
+	dim := int(math.Round(d))
+	switch dim & 3 {
+	case 0:
+		dim++
+	case 2:
+		dim--
+	case 3:
+		dim -= 2
+	}
+	return dim, (modTR + modBL) / 2
+}
+
+// moduleAlong 从定位图形中心沿 from→to 方向及反方向各测量 深-浅-深 三段，
+// 两侧合计为 7 个模块
+func (b *bitmap) moduleAlong(from, to point) float64 {
+	d := dist(from, to)
+	if d == 0 {
+		return 0
+	}
+	ux, uy := (to.x-from.x)/d, (to.y-from.y)/d
+	// 以半个像素为步长采样，边缘位于上一个采样点和当前采样点之间，取中点避免整体偏大
+	const step = 0.5
+	run := func(sx, sy float64) float64 {
+		transitions := 0
+		prev := true
+		for i := 0.0; i < d; i += step {
+			x, y := from.x+sx*i, from.y+sy*i
+			dark := b.get(int(math.Floor(x)), int(math.Floor(y)))
+			if dark != prev {
+				transitions++
+				prev = dark
+				if transitions == 3 {
+					return i - step/2
+				}
+			}
+		}
+		return -1
+	}
+	a, c := run(ux, uy), run(-ux, -uy)
+	if a < 0 || c < 0 {
+		return 0
+	}
+	return (a + c) / 7
+}
+
+// timingDimension 沿第 6 行和第 6 列的定时图形统计深浅交替次数得到边长，
+// 透视较强时比按间距估计更准确；两条定时图形结果不一致时返回 0
+func (b *bitmap) timingDimension(t triple) int {
+	// 定位图形中心在第 3.5 个模块，定时图形两端在第 6.5 个模块，向内偏移 3 个模块
+	offset := func(f *finder, toward *finder, other *finder) point {
+		m1 := b.moduleAlong(f.point, toward.point)
+		m2 := b.moduleAlong(f.point, other.point)
+		if m1 <= 0 || m2 <= 0 {
+			m1, m2 = f.module, f.module
+		}
+		d1, d2 := dist(f.point, toward.point), dist(f.point, other.point)
+		return point{
+			f.x + 3*m1*(toward.x-f.x)/d1 + 3*m2*(other.x-f.x)/d2,
+			f.y + 3*m1*(toward.y-f.y)/d1 + 3*m2*(other.y-f.y)/d2,
+		}
+	}
+	// 右上、左下两个定位图形的"向内"方向用左上定位图形的方向近似
+	trIn := point{t.tr.x + t.bl.x - t.tl.x, t.tr.y + t.bl.y - t.tl.y}
+	blIn := point{t.bl.x + t.tr.x - t.tl.x, t.bl.y + t.tr.y - t.tl.y}
+	row := b.countRuns(offset(t.tl, t.tr, t.bl), offset(t.tr, t.tl, &finder{point: trIn, module: t.tr.module}))
+	col := b.countRuns(offset(t.tl, t.bl, t.tr), offset(t.bl, t.tl, &finder{point: blIn, module: t.bl.module}))
+	if row != col || row < 8 {
+		return 0
+	}
+	// 第 6 到第 dim-7 个模块严格交替，共 dim-12 段
+	dim := row + 12
+	if dim&3 != 1 || dim > 177 {
+		return 0
+	}
+	return dim
+}
+
+// countRuns 统计线段上深浅交替的段数，短于半个模块的段视为噪点
+func (b *bitmap) countRuns(from, to point) int {
+	d := dist(from, to)
+	if d < 1 {
+		return 0
+	}
+	type run struct {
+		dark bool
+		n    int
+	}
+	var runs []run
+	for i := 0.0; i <= d; i += 0.5 {
+		dark := b.get(int(from.x+(to.x-from.x)*i/d), int(from.y+(to.y-from.y)*i/d))
+		if len(runs) > 0 && runs[len(runs)-1].dark == dark {
+			runs[len(runs)-1].n++
+		} else {
+			runs = append(runs, run{dark, 1})
+		}
+	}
+	// 估计模块长度：平均每段的采样数，除以 2 后作为噪点阈值（采样步长为半个像素）
+	minRun := len(runs)
+	if minRun > 0 {
+		minRun = int(2*d) / len(runs) / 2
+	}
+	merged := runs[:0]
+	for _, r := range runs {
+		if n := len(merged); n > 0 && (merged[n-1].dark == r.dark || r.n < minRun) {
+			merged[n-1].n += r.n
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return len(merged)
+}
+
+// findAlignments 在估计位置附近查找校正图形候选，按与估计位置的距离排序
+// 透视较强时估计位置可能偏差十几个模块，高版本的区域内还会有其它校正图形，
+// 因此返回全部候选由调用方逐个尝试；ux、uy 为一个模块在图像中的横、纵向量
+func (b *bitmap) findAlignments(est point, module float64, dim int, ux, uy point) []point {
+	// 估计偏差随边长增大，搜索半径取 16 个模块与边长四分之一中的较大者
+	r := int(math.Max(16, float64(dim)/4) * module)
+	x0, x1 := int(est.x)-r, int(est.x)+r
+	y0, y1 := int(est.y)-r, int(est.y)+r
+	var found []point
+	add := func(c point) {
+		for _, f := range found {
+			if dist(f, c) < module {
+				return
+			}
+		}
+		found = append(found, c)
+	}
+	for y := max(y0, 0); y <= min(y1, b.h-1); y++ {
+		// 查找 浅-深-浅 三段长度约为一个模块的游程
+		var runs [3]int
+		state := -1
+		for x := max(x0, 0); x <= min(x1, b.w-1); x++ {
+			dark := b.get(x, y)
+			switch state {
+			case -1:
+				if !dark {
+					state, runs = 0, [3]int{1, 0, 0}
+				}
+			case 0:
+				if dark {
+					state, runs[1] = 1, 1
+				} else {
+					runs[0]++
+				}
+			case 1:
+				if dark {
+					runs[1]++
+				} else {
+					state, runs[2] = 2, 1
+				}
+			case 2:
+				if !dark {
+					runs[2]++
+					continue
+				}
+				if alignRatio(runs, module) {
+					cx := float64(x) - float64(runs[2]) - float64(runs[1])/2
+					if c, local, ok := b.checkAlignment(cx, y, module); ok && b.alignmentTemplate(c, local, ux, uy) {
+						add(c)
+					}
+				}
+				// 第三段浅色作为下一组的第一段
+				state, runs = 1, [3]int{runs[2], 1, 0}
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return dist(found[i], est) < dist(found[j], est) })
+	return found
+}
+
+// alignmentTemplate 按 5×5 模板校验校正图形：外圈深、内圈浅、中心深，允许少量误差
+// 模块方向取 ux、uy，长度按局部测得的模块边长缩放，以适应透视造成的大小变化
+func (b *bitmap) alignmentTemplate(c point, local float64, ux, uy point) bool {
+	if lx, ly := math.Hypot(ux.x, ux.y), math.Hypot(uy.x, uy.y); lx > 0 && ly > 0 {
+		ux = point{ux.x * local / lx, ux.y * local / lx}
+		uy = point{uy.x * local / ly, uy.y * local / ly}
+	}
+	miss := 0
+	for j := -2; j <= 2; j++ {
+		for i := -2; i <= 2; i++ {
+			x := c.x + float64(i)*ux.x + float64(j)*uy.x
+			y := c.y + float64(i)*ux.y + float64(j)*uy.y
+			ring := max(abs(i), abs(j))
+			if b.get(int(x), int(y)) != (ring != 1) {
+				miss++
+			}
+		}
+	}
+	return miss <= 2
+}
+
+func alignRatio(runs [3]int, module float64) bool {
+	for _, r := range runs {
+		if math.Abs(float64(r)-module) >= module/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAlignment 纵向验证校正图形中心：深色中心上下各有约一个模块的浅色，再往外为深色
+// 同时返回按中心和内圈测得的局部模块边长
+func (b *bitmap) checkAlignment(cx float64, y int, module float64) (point, float64, bool) {
+	x := int(cx)
+	if !b.get(x, y) {
+		return point{}, 0, false
+	}
+	top, bottom := y, y
+	for b.get(x, top-1) {
+		top--
+	}
+	for b.get(x, bottom+1) {
+		bottom++
+	}
+	center := float64(bottom - top + 1)
+	lightUp, lightDown := 0, 0
+	for b.inside(x, top-1-lightUp) && !b.get(x, top-1-lightUp) {
+		lightUp++
+	}
+	for b.inside(x, bottom+1+lightDown) && !b.get(x, bottom+1+lightDown) {
+		lightDown++
+	}
+	if !b.get(x, top-1-lightUp) || !b.get(x, bottom+1+lightDown) {
+		return point{}, 0, false
+	}
+	for _, r := range []float64{center, float64(lightUp), float64(lightDown)} {
+		if math.Abs(r-module) >= module/2 {
+			return point{}, 0, false
+		}
+	}
+	c := point{b.horizontalCenter(x, int(float64(top+bottom)/2)), float64(top+bottom+1) / 2}
+	return c, (center + float64(lightUp) + float64(lightDown)) / 3, true
+}
+
+// transform 3×3 透视变换矩阵，作用于齐次坐标列向量
+type transform [3][3]float64
+
+func (m transform) apply(p point) point {
+	w := m[2][0]*p.x + m[2][1]*p.y + m[2][2]
+	return point{
+		(m[0][0]*p.x + m[0][1]*p.y + m[0][2]) / w,
+		(m[1][0]*p.x + m[1][1]*p.y + m[1][2]) / w,
+	}
+}
+
+func (m transform) mul(o transform) transform {
+	var r transform
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// adjugate 伴随矩阵，与逆矩阵只差一个比例因子
+func (m transform) adjugate() transform {
+	return transform{
+		{m[1][1]*m[2][2] - m[1][2]*m[2][1], m[0][2]*m[2][1] - m[0][1]*m[2][2], m[0][1]*m[1][2] - m[0][2]*m[1][1]},
+		{m[1][2]*m[2][0] - m[1][0]*m[2][2], m[0][0]*m[2][2] - m[0][2]*m[2][0], m[0][2]*m[1][0] - m[0][0]*m[1][2]},
+		{m[1][0]*m[2][1] - m[1][1]*m[2][0], m[0][1]*m[2][0] - m[0][0]*m[2][1], m[0][0]*m[1][1] - m[0][1]*m[1][0]},
+	}
+}
+
+// squareToQuad 将单位正方形 (0,0) (1,0) (1,1) (0,1) 映射到四边形 p
+func squareToQuad(p [4]point) transform {
+	dx3 := p[0].x - p[1].x + p[2].x - p[3].x
+	dy3 := p[0].y - p[1].y + p[2].y - p[3].y
+	if dx3 == 0 && dy3 == 0 {
+		return transform{
+			{p[1].x - p[0].x, p[2].x - p[1].x, p[0].x},
+			{p[1].y - p[0].y, p[2].y - p[1].y, p[0].y},
+			{0, 0, 1},
+		}
+	}
+	dx1, dx2 := p[1].x-p[2].x, p[3].x-p[2].x
+	dy1, dy2 := p[1].y-p[2].y, p[3].y-p[2].y
+	den := dx1*dy2 - dx2*dy1
+	g := (dx3*dy2 - dx2*dy3) / den
+	h := (dx1*dy3 - dx3*dy1) / den
+	return transform{
+		{p[1].x - p[0].x + g*p[1].x, p[3].x - p[0].x + h*p[3].x, p[0].x},
+		{p[1].y - p[0].y + g*p[1].y, p[3].y - p[0].y + h*p[3].y, p[0].y},
+		{g, h, 1},
+	}
+}
+
+// quadToQuad 将四边形 src 映射到 dst
+func quadToQuad(src, dst [4]point) transform {
+	return squareToQuad(dst).mul(squareToQuad(src).adjugate())
+}
+
+// sample 按透视变换读取每个模块中心的颜色
+func (b *bitmap) sample(t triple, dim int, align *point) *grid {
+	n := float64(dim)
+	src := [4]point{{3.5, 3.5}, {n - 3.5, 3.5}, {n - 3.5, n - 3.5}, {3.5, n - 3.5}}
+	br := point{t.tr.x - t.tl.x + t.bl.x, t.tr.y - t.tl.y + t.bl.y}
+	if align != nil {
+		src[2] = point{n - 6.5, n - 6.5}
+		br = *align
+	}
+	m := quadToQuad(src, [4]point{t.tl.point, t.tr.point, br, t.bl.point})
+
+	g := newGrid(dim)
+	for row := 0; row < dim; row++ {
+		for col := 0; col < dim; col++ {
+			p := m.apply(point{float64(col) + 0.5, float64(row) + 0.5})
+			g.set(row, col, b.get(int(math.Floor(p.x)), int(math.Floor(p.y))))
+		}
+	}
+	return g
+}
+
+// axes 按边长估计一个模块在图像中的横、纵向量
+func (t triple) axes(dim int) (point, point) {
+	n := float64(dim - 7)
+	return point{(t.tr.x - t.tl.x) / n, (t.tr.y - t.tl.y) / n}, point{(t.bl.x - t.tl.x) / n, (t.bl.y - t.tl.y) / n}
+}
+
+// alignmentEstimate 右下角校正图形在图像中的估计位置
+func (t triple) alignmentEstimate(dim int) point {
+	br := point{t.tr.x - t.tl.x + t.bl.x, t.tr.y - t.tl.y + t.bl.y}
+	k := 1 - 3/float64(dim-7)
+	return point{t.tl.x + k*(br.x-t.tl.x), t.tl.y + k*(br.y-t.tl.y)}
+}
@@ -0,0 +1,184 @@
+// Package qrcode 纯 Go 实现的二维码识别，用于从频道图片中读取活动链接
+//
+// 支持版本 1-40、全部纠错等级，以及数字、字母数字、字节、汉字（Shift JIS）和 ECI 分段。
+// 图片需大致正对拍摄，支持缩放、旋转和轻微透视变形，不支持镜像。
+package qrcode
+
+import (
+	"image"
+)
+
+const (
+	// 单张图片最多尝试的定位图形组合数
+	maxTriples = 20
+	// 每个边长最多尝试的校正图形候选数
+	maxAlignments = 6
+)
+
+// Decode 识别图片中的所有二维码，返回去重后的文本
+func Decode(img image.Image) []string {
+	lum := luminance(img)
+	var out []string
+	seen := make(map[string]bool)
+	for _, bin := range []func(*grayImage) *bitmap{otsu, adaptive} {
+		for _, text := range bin(lum).decodeAll() {
+			if !seen[text] {
+				seen[text] = true
+				out = append(out, text)
+			}
+		}
+		if len(out) > 0 {
+			break
+		}
+	}
+	return out
+}
+
+// decodeAll 依次尝试吻合度最高的定位图形组合，已成功识别的定位图形不再复用
+func (b *bitmap) decodeAll() []string {
+	var out []string
+	used := make(map[*finder]bool)
+	triples := candidateTriples(b.findFinders())
+	if len(triples) > maxTriples {
+		triples = triples[:maxTriples]
+	}
+	for _, t := range triples {
+		if used[t.tl] || used[t.tr] || used[t.bl] {
+			continue
+		}
+		if text, ok := b.decodeTriple(t); ok {
+			out = append(out, text)
+			used[t.tl], used[t.tr], used[t.bl] = true, true, true
+		}
+	}
+	return out
+}
+
+func (b *bitmap) decodeTriple(t triple) (string, bool) {
+	est, module := b.dimension(t)
+	tried := make(map[int]bool)
+	// 定时图形计数最准确；按间距估计的边长可能差一个版本，版本 7 以上再用读到的版本信息修正
+	dims := []int{est, est - 4, est + 4}
+	if td := b.timingDimension(t); td > 0 && td != est {
+		dims = append([]int{td}, dims...)
+	}
+	for i := 0; i < len(dims); i++ {
+		dim := dims[i]
+		if dim < 21 || dim > 177 || tried[dim] {
+			continue
+		}
+		tried[dim] = true
+		// 依次尝试附近的校正图形，最后退回仿射变换
+		aligns := []*point{nil}
+		if dim > 21 {
+			ux, uy := t.axes(dim)
+			found := b.findAlignments(t.alignmentEstimate(dim), module, dim, ux, uy)
+			if len(found) > maxAlignments {
+				found = found[:maxAlignments]
+			}
+			aligns = aligns[:0]
+			for i := range found {
+				aligns = append(aligns, &found[i])
+			}
+			aligns = append(aligns, nil)
+		}
+		for _, a := range aligns {
+			g := b.sample(t, dim, a)
+			if text, err := decodeGrid(g); err == nil {
+				return text, true
+			}
+			if dim >= 45 {
+				if v, ok := g.version(); ok && v*4+17 != dim && !tried[v*4+17] {
+					dims = append(dims, v*4+17)
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+type grayImage struct {
+	w, h int
+	pix  []uint8
+}
+
+func luminance(img image.Image) *grayImage {
+	r := img.Bounds()
+	g := &grayImage{w: r.Dx(), h: r.Dy(), pix: make([]uint8, r.Dx()*r.Dy())}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			cr, cg, cb, ca := img.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			// 透明像素视为白色背景
+			l := (299*cr + 587*cg + 114*cb) / 1000
+			l = l*ca/0xffff + (0xffff - ca)
+			g.pix[y*g.w+x] = uint8(l >> 8)
+		}
+	}
+	return g
+}
+
+// otsu 全局阈值，适合截图和生成的二维码图片
+func otsu(g *grayImage) *bitmap {
+	var hist [256]int
+	for _, p := range g.pix {
+		hist[p]++
+	}
+	total := len(g.pix)
+	sum := 0
+	for i, c := range hist {
+		sum += i * c
+	}
+	var sumB, wB int
+	best, threshold := 0.0, 128
+	for t := 0; t < 256; t++ {
+		wB += hist[t]
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += t * hist[t]
+		mB := float64(sumB) / float64(wB)
+		mF := float64(sum-sumB) / float64(wF)
+		between := float64(wB) * float64(wF) * (mB - mF) * (mB - mF)
+		if between > best {
+			best, threshold = between, t
+		}
+	}
+	b := &bitmap{w: g.w, h: g.h, bits: make([]bool, len(g.pix))}
+	for i, p := range g.pix {
+		b.bits[i] = int(p) <= threshold
+	}
+	return b
+}
+
+// adaptive 局部均值阈值，适合光照不均的照片
+func adaptive(g *grayImage) *bitmap {
+	w, h := g.w, g.h
+	integral := make([]int64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row int64
+		for x := 0; x < w; x++ {
+			row += int64(g.pix[y*w+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+	r := max(w, h) / 16
+	if r < 8 {
+		r = 8
+	}
+	b := &bitmap{w: w, h: h, bits: make([]bool, len(g.pix))}
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-r, 0), min(y+r+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-r, 0), min(x+r+1, w)
+			s := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			n := int64((y1 - y0) * (x1 - x0))
+			// 比局部均值暗 7% 以上视为深色
+			b.bits[y*w+x] = int64(g.pix[y*w+x])*n*100 < s*93
+		}
+	}
+	return b
+}
@@ -0,0 +1,59 @@
+package qrcode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"rsc.io/qr/coding"
+)
+
+// encode 生成指定版本和纠错等级的二维码，尽量填满数据区
+func encode(t *testing.T, version int, level coding.Level) (string, *coding.Code) {
+	t.Helper()
+	v := coding.Version(version)
+	plan, err := coding.NewPlan(v, level, coding.Mask(version%8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := fmt.Sprintf("%d%s:", version, level)
+	for coding.String(text+"x").Bits(v) <= plan.DataBytes*8 {
+		text += string(rune('a' + len(text)%26))
+	}
+	code, err := plan.Encode(coding.String(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text, code
+}
+
+// render 按 scale 像素一个模块绘制，四周留 4 个模块的空白
+func render(code *coding.Code, scale int) image.Image {
+	size := (code.Size + 8) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := color.Gray{Y: 0xff}
+			if code.Black(x/scale-4, y/scale-4) {
+				c.Y = 0
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for version := 1; version <= 40; version++ {
+		for _, level := range []coding.Level{coding.L, coding.M, coding.Q, coding.H} {
+			text, code := encode(t, version, level)
+			for _, scale := range []int{1, 2, 4} {
+				got := Decode(render(code, scale))
+				if len(got) != 1 || got[0] != text {
+					t.Errorf("版本 %d 等级 %s 缩放 %d: got %q", version, level, scale, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package qrcode
+
+import "errors"
+
+var errTooManyErrors = errors.New("纠错失败")
+
+// GF(256)，本原多项式 x^8+x^4+x^3+x^2+1
+var gfExp, gfLog [512]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow 返回 α^n
+func gfPow(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return gfExp[n]
+}
+
+// polyEval 计算低次在前的多项式在 x 处的值
+func polyEval(p []byte, x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// rsCorrect 就地纠正一个分块，block 为高次在前的码字，ecLen 为纠错码字数
+// QR 码生成多项式的根为 α^0 … α^(ecLen-1)
+func rsCorrect(block []byte, ecLen int) error {
+	n := len(block)
+
+	synd := make([]byte, ecLen)
+	clean := true
+	for j := 0; j < ecLen; j++ {
+		x := gfPow(j)
+		var s byte
+		for _, c := range block {
+			s = gfMul(s, x) ^ c
+		}
+		synd[j] = s
+		if s != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return nil
+	}
+
+	// Berlekamp-Massey 求错误位置多项式（低次在前）
+	lambda := []byte{1}
+	prev := []byte{1}
+	l, m, b := 0, 1, byte(1)
+	for r := 0; r < ecLen; r++ {
+		d := synd[r]
+		for i := 1; i <= l && i < len(lambda); i++ {
+			d ^= gfMul(lambda[i], synd[r-i])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		coef := gfDiv(d, b)
+		next := make([]byte, max(len(lambda), len(prev)+m))
+		copy(next, lambda)
+		for i, p := range prev {
+			next[i+m] ^= gfMul(coef, p)
+		}
+		if 2*l <= r {
+			prev, l, b, m = lambda, r+1-l, d, 1
+		} else {
+			m++
+		}
+		lambda = next
+	}
+	if 2*l > ecLen {
+		return errTooManyErrors
+	}
+
+	// Chien 搜索：位置 i 对应 X = α^(n-1-i)，Λ(X^-1) = 0 即为错误位置
+	var positions []int
+	for i := 0; i < n; i++ {
+		if polyEval(lambda, gfPow(-(n-1-i))) == 0 {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) != l {
+		return errTooManyErrors
+	}
+
+	// Forney：Ω = S·Λ mod x^ecLen，错误值 e = X·Ω(X^-1)/Λ'(X^-1)
+	omega := make([]byte, ecLen)
+	for i := 0; i < ecLen; i++ {
+		for j := 0; j <= i && j < len(lambda); j++ {
+			omega[i] ^= gfMul(lambda[j], synd[i-j])
+		}
+	}
+	deriv := make([]byte, len(lambda))
+	for i := 1; i < len(lambda); i += 2 {
+		deriv[i-1] = lambda[i]
+	}
+	for _, pos := range positions {
+		x := gfPow(n - 1 - pos)
+		xInv := gfInv(x)
+		den := polyEval(deriv, xInv)
+		if den == 0 {
+			return errTooManyErrors
+		}
+		block[pos] ^= gfMul(x, gfDiv(polyEval(omega, xInv), den))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package qrcode
+
+// 纠错等级，按格式信息中的编码取值
+const (
+	levelM = 0
+	levelL = 1
+	levelH = 2
+	levelQ = 3
+)
+
+// 每个纠错分块的纠错码字数，下标为 [等级][版本]
+var ecCodewordsPerBlock = [4][41]int{
+	levelL: {-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	levelM: {-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	levelQ: {-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	levelH: {-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// 纠错分块数，下标为 [等级][版本]
+var ecBlocks = [4][41]int{
+	levelL: {-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	levelM: {-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	levelQ: {-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	levelH: {-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// alignmentPositions 返回校正图形中心所在的行/列坐标
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	num := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + num*2 + 1) / (num*2 - 2) * 2
+	}
+	pos := make([]int, num)
+	pos[0] = 6
+	for i, p := num-1, version*4+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// rawCodewords 返回版本的码字总数（数据 + 纠错）
+func rawCodewords(version int) int {
+	bits := (16*version+128)*version + 64
+	if version >= 2 {
+		num := version/7 + 2
+		bits -= (25*num-10)*num - 55
+		if version >= 7 {
+			bits -= 36
+		}
+	}
+	return bits / 8
+}
+
+// bch 计算 BCH 校验位，返回 value 与校验位拼接后的结果
+func bch(value, poly int) int {
+	deg := bitLen(poly) - 1
+	rem := value << deg
+	for bitLen(rem) > deg {
+		rem ^= poly << (bitLen(rem) - deg - 1)
+	}
+	return value<<deg | rem
+}
+
+func bitLen(v int) int {
+	n := 0
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+func hamming(a, b int) int {
+	return popCount(a ^ b)
+}
+
+func popCount(v int) int {
+	n := 0
+	for ; v != 0; v &= v - 1 {
+		n++
+	}
+	return n
+}
+
+const (
+	formatPoly  = 0x537
+	formatMask  = 0x5412
+	versionPoly = 0x1f25
+)
+
+// decodeFormat 从两份格式信息中取汉明距离最近的有效编码，返回纠错等级和掩码
+func decodeFormat(bits1, bits2 int) (level, mask int, ok bool) {
+	best, bestDist := 0, 4
+	for data := 0; data < 32; data++ {
+		code := bch(data, formatPoly) ^ formatMask
+		for _, bits := range []int{bits1, bits2} {
+			if d := hamming(code, bits); d < bestDist {
+				best, bestDist = data, d
+			}
+		}
+	}
+	if bestDist > 3 {
+		return 0, 0, false
+	}
+	return best >> 3, best & 7, true
+}
+
+// decodeVersion 从两份版本信息中取汉明距离最近的版本
+func decodeVersion(bits1, bits2 int) (int, bool) {
+	best, bestDist := 0, 4
+	for v := 7; v <= 40; v++ {
+		code := bch(v, versionPoly)
+		for _, bits := range []int{bits1, bits2} {
+			if d := hamming(code, bits); d < bestDist {
+				best, bestDist = v, d
+			}
+		}
+	}
+	return best, bestDist <= 3
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -14,6 +15,120 @@ import (
 
 type ChannelTarget struct {
 	Username string `json:"username"`
+	// 按消息实体提取变量：all（默认，整条消息）、only（仅代码/预格式/剧透块）、prefer（优先这些块，无结果时退回整条消息）
+	Entities string `json:"entities"`
+	// 消息格式：shell（默认，只接受 export 语句）、auto（自动识别）、dotenv、json、yaml
+	// 配置了 rules 时默认只用规则提取，显式设置 format 则两者都用
+	Format string      `json:"format"`
+	Rules  []RegexRule `json:"rules"`
+	// 自动识别并解码 base64、base64url 和百分号编码的内容后重新解析
+	Decode      bool `json:"decode"`
+	DecodeDepth int  `json:"decode_depth"` // 最多解码层数，默认 2，上限 5
+	// 来源消息被删除时对其写入的变量：none（默认，不处理）、restore（恢复写入前的值）、disable（禁用）
+	OnDelete string `json:"on_delete"`
+	// 变量名允许/禁止列表，与全局列表同时生效，支持 * ? 通配符和 /正则/
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// RegexRule 正则提取规则，name/value 为模板，可用 $group 或 ${group} 引用命名分组
+type RegexRule struct {
+	Pattern string `json:"pattern"`
+	Name    string `json:"name"`  // 为空时取 name 分组
+	Value   string `json:"value"` // 为空时取 value 分组
+}
+
+// QLConfig 青龙面板连接配置
+type QLConfig struct {
+	Name         string `json:"name"` // 实例名称，用于路由和通知，默认取 base_url 的主机名
+	BaseURL      string `json:"base_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Timeout      int    `json:"timeout"` // 单次请求超时（秒），0 使用默认值
+	Retries      int    `json:"retries"` // 失败重试次数，0 使用默认值，-1 不重试
+
+	// 存在多个同名变量时的处理方式: first / all / enabled / create
+	DuplicatePolicy string `json:"duplicate_policy"`
+
+	// 多值变量的合并规则，按顺序匹配第一条
+	EnvModes []EnvModeConfig `json:"env_modes"`
+
+	// 变量别名规则，实际写入的别名也按前缀搜索脚本；不配置时默认 lzkj → lzkj_v2，配置为 [] 关闭
+	Aliases []AliasConfig `json:"aliases"`
+
+	// 脚本运行结果跟踪
+	TrackInterval int      `json:"track_interval"` // 轮询间隔（秒），默认 10
+	TrackTimeout  int      `json:"track_timeout"`  // 最长等待（秒），默认 1800，-1 不跟踪
+	FailPatterns  []string `json:"fail_patterns"`  // 日志中出现即视为失败的内容
+
+	// 脚本触发限制
+	Cooldown      int    `json:"cooldown"`       // 同一脚本两次触发的最小间隔（秒），0 不限制
+	MaxConcurrent int    `json:"max_concurrent"` // 同时运行的已触发脚本上限，0 不限制（需开启结果跟踪）
+	IfRunning     string `json:"if_running"`     // 脚本正在运行时: skip / queue / restart，空为直接触发
+
+	Notify NotifyConfig `json:"notify"` // 不配置时由第一个实例代发通知
+}
+
+// ValueRule 变量值的转换和校验规则，先转换后校验
+type ValueRule struct {
+	Name       string   `json:"name"`        // 变量名，支持 * ? 通配符和 /正则/
+	Transform  []string `json:"transform"`   // 依次执行: trim / lower / upper / query:参数名（取链接中的参数）
+	Pattern    string   `json:"pattern"`     // 值须匹配的正则
+	MaxLength  int      `json:"max_length"`  // 最大字符数，不配置时不限制
+	SingleLine bool     `json:"single_line"` // 拒绝换行等控制字符，多行值和换行分隔的列表不要开启
+	Charset    string   `json:"charset"`     // 允许的字符: digit / hex / alnum / word / url，或正则字符类如 [A-Za-z0-9_-]
+	Hosts      []string `json:"hosts"`       // 值须为链接且主机名匹配其一，支持通配符
+}
+
+// AliasConfig 变量别名规则：变量名中第一处匹配被替换为 replace 中的每一项
+type AliasConfig struct {
+	Match   string   `json:"match"`   // 要替换的文本，regex 为 true 时为正则
+	Regex   bool     `json:"regex"`   // 正则模式下 replace 可用 $1、${name} 引用分组
+	Replace []string `json:"replace"` // 替换后的文本，每项生成一个别名
+	Rename  bool     `json:"rename"`  // 只写入别名，不再写入原名
+}
+
+// EnvModeConfig 单个（或一组）变量的合并方式
+type EnvModeConfig struct {
+	Name      string `json:"name"`      // 变量名，支持 * ? 通配符
+	Mode      string `json:"mode"`      // replace / append / prepend / keep_last
+	Separator string `json:"separator"` // 多值分隔符，默认 &
+	Keep      int    `json:"keep"`      // 最多保留的条目数，0 不限制；keep_last 必须大于 0
+}
+
+// NotifyConfig 通过青龙脚本发送通知的配置
+type NotifyConfig struct {
+	ScriptFile string `json:"scriptfile"`
+	ScriptPath string `json:"scriptpath"`
+	Template   string `json:"template"`
+}
+
+// QLInstances 青龙实例列表，兼容旧版单个对象的写法
+type QLInstances []QLConfig
+
+func (q *QLInstances) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var one QLConfig
+		if err := json.Unmarshal(data, &one); err != nil {
+			return err
+		}
+		*q = QLInstances{one}
+		return nil
+	}
+	var many []QLConfig
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*q = many
+	return nil
+}
+
+// RouteConfig 变量路由规则，条件为空表示不限制
+type RouteConfig struct {
+	Vars      []string `json:"vars"`      // 变量名，支持 * ? 通配符
+	Sources   []string `json:"sources"`   // 来源频道/用户的 username
+	Instances []string `json:"instances"` // 目标青龙实例名称
 }
 
 type Config struct {
@@ -24,22 +139,58 @@ type Config struct {
 		Phone   string `json:"phone"`
 	} `json:"telegram"`
 
-	QL struct {
-		BaseURL      string `json:"base_url"`
-		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
+	QL QLInstances `json:"ql"`
+
+	// 变量名允许/禁止列表，支持 * ? 通配符和 /正则/；禁止优先，允许列表为空时不限制
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+
+	// 变量值规则，按顺序匹配第一条；未命中任何规则的值原样写入，只有规则开启 single_line 时才拒绝换行等控制字符
+	ValueRules []ValueRule `json:"value_rules"`
 
-		Notify struct {
-			ScriptFile string `json:"scriptfile"`
-			ScriptPath string `json:"scriptpath"`
-			Template   string `json:"template"`
-		} `json:"notify"`
-	} `json:"ql"`
+	// 变量路由规则，命中的规则目标取并集，均未命中时写入全部实例
+	Routes []RouteConfig `json:"routes"`
 
 	Listen struct {
 		Channels []ChannelTarget `json:"channels"`
 		Users    []ChannelTarget `json:"users"`
 	} `json:"listen"`
+
+	Aggregate AggregateConfig `json:"aggregate"`
+
+	Documents DocumentConfig `json:"documents"`
+	QRCode    QRCodeConfig   `json:"qrcode"`
+
+	// 链接到变量的映射规则，作用于正文、按钮、文字链接、附件和二维码中的链接
+	URLRules []URLRule `json:"url_rules"`
+}
+
+// URLRule 按主机和路径匹配链接，把查询参数映射为变量
+type URLRule struct {
+	Host   string            `json:"host"`   // 主机名通配，如 *.isvjcloud.com，为空匹配任意主机
+	Path   string            `json:"path"`   // 路径通配，如 /wxTeam/*，为空匹配任意路径
+	Params map[string]string `json:"params"` // 查询参数名 → 变量名，如 activityId → jd_lzkj_wxTeam_activityId
+	Var    string            `json:"var"`    // 完整链接写入的变量名，可选
+}
+
+// AggregateConfig 相册和连续消息的聚合设置
+type AggregateConfig struct {
+	// 等待时间（秒）：同一相册或同一发送者的消息在最后一条之后这么久没有新消息时合并处理，0 不聚合
+	Window int `json:"window"`
+}
+
+// QRCodeConfig 识别图片消息中的二维码，识别出的文本与消息正文一样解析
+type QRCodeConfig struct {
+	Enabled bool  `json:"enabled"`
+	MaxSize int64 `json:"max_size"` // 字节，默认 5 MiB
+}
+
+// DocumentConfig 从消息附件中解析变量
+type DocumentConfig struct {
+	Enabled    bool     `json:"enabled"`
+	MaxSize    int64    `json:"max_size"`   // 字节，默认 1 MiB
+	MimeTypes  []string `json:"mime_types"` // 允许的 MIME 类型，支持 text/* 通配
+	Extensions []string `json:"extensions"` // 允许的扩展名，如 .sh
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -0,0 +1,109 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"telegram-env-watcher/utils"
+)
+
+// 单个批次最多合并的消息数，达到后立即处理
+const maxAggregateMessages = 20
+
+// batch 等待合并处理的一组消息
+type batch struct {
+	id    int64 // 会话 ID
+	tgt   *target
+	peer  string
+	msgs  []*tg.Message
+	timer *time.Timer
+}
+
+// aggregator 收集同一相册（GroupedID 相同）或同一会话中同一发送者的连续消息，
+// 最后一条消息之后 window 内没有新消息时合并处理
+type aggregator struct {
+	window time.Duration
+	handle func(ctx context.Context, b *batch)
+
+	mu      sync.Mutex
+	pending map[string]*batch
+}
+
+func newAggregator(window time.Duration, handle func(ctx context.Context, b *batch)) *aggregator {
+	return &aggregator{window: window, handle: handle, pending: make(map[string]*batch)}
+}
+
+// aggregateKey 相册按 GroupedID 归组，其他消息按会话和发送者归组
+func aggregateKey(id int64, msg *tg.Message) string {
+	if gid, ok := msg.GetGroupedID(); ok {
+		return fmt.Sprintf("%d/album/%d", id, gid)
+	}
+	var sender int64
+	if from, ok := msg.GetFromID(); ok {
+		sender = utils.PeerIDFromPeer(from)
+	}
+	return fmt.Sprintf("%d/sender/%d", id, sender)
+}
+
+func (a *aggregator) add(ctx context.Context, id int64, tgt *target, peer string, msg *tg.Message) {
+	key := aggregateKey(id, msg)
+
+	a.mu.Lock()
+	b, ok := a.pending[key]
+	if ok {
+		b.timer.Reset(a.window)
+	} else {
+		b = &batch{id: id, tgt: tgt, peer: peer}
+		a.pending[key] = b
+		b.timer = time.AfterFunc(a.window, func() { a.flush(ctx, key, b) })
+	}
+	b.msgs = append(b.msgs, msg)
+	full := len(b.msgs) >= maxAggregateMessages
+	a.mu.Unlock()
+
+	if full {
+		a.flush(ctx, key, b)
+	}
+}
+
+// flush 取出批次并处理，定时器和消息数上限可能同时触发，只处理一次
+func (a *aggregator) flush(ctx context.Context, key string, b *batch) {
+	a.mu.Lock()
+	if a.pending[key] != b {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.pending, key)
+	b.timer.Stop()
+	a.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+	// 相册中的消息可能乱序到达，按消息 ID 还原发布顺序
+	sort.Slice(b.msgs, func(i, j int) bool { return b.msgs[i].ID < b.msgs[j].ID })
+	a.handle(ctx, b)
+}
+
+// replace 消息在聚合窗口内被编辑时，用新内容替换批次中的旧消息
+func (a *aggregator) replace(id int64, msg *tg.Message) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.pending {
+		if b.id != id {
+			continue
+		}
+		for i, m := range b.msgs {
+			if m.ID == msg.ID {
+				b.msgs[i] = msg
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultDecodeDepth = 2
+	maxDecodeDepth     = 5
+	minBase64Len       = 16
+)
+
+var (
+	base64Regexp  = regexp.MustCompile(`[A-Za-z0-9+/_-]{16,}={0,2}`)
+	percentRegexp = regexp.MustCompile(`(?:%[0-9A-Fa-f]{2})+`)
+	// 整体为百分号编码：只含未保留字符和 %XX，出现 : / ? & = 等原始字符说明只是部分编码（如链接中的参数）
+	percentEncodedRegexp = regexp.MustCompile(`^(?:[A-Za-z0-9._~-]|%[0-9A-Fa-f]{2})+$`)
+	tokenRegexp          = regexp.MustCompile(`\S+`)
+	// 整段文本只含 base64 字符和空白时视为一个（可能按行折断的）编码块
+	base64BlockRegexp = regexp.MustCompile(`^[A-Za-z0-9+/_=\-\s]+$`)
+)
+
+var base64Encodings = []*base64.Encoding{
+	base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+}
+
+// decodingParser 在普通解析之外，识别文本中的 base64 / base64url / 百分号编码块，
+// 解码后重新解析；解码出的变量覆盖外层同名变量（外层拿到的是编码后的值），
+// 但外层的值已经是有效链接时保留外层的值。整体为百分号编码的变量值直接解码。
+type decodingParser struct {
+	Parser
+	depth int
+}
+
+// newDecodingParser depth 为 0 时使用默认层数
+func newDecodingParser(p Parser, depth int) (Parser, error) {
+	if depth < 0 || depth > maxDecodeDepth {
+		return nil, fmt.Errorf("decode_depth 须在 0-%d 之间: %d", maxDecodeDepth, depth)
+	}
+	if depth == 0 {
+		depth = defaultDecodeDepth
+	}
+	return decodingParser{Parser: p, depth: depth}, nil
+}
+
+func (p decodingParser) Parse(text string) ([]Var, []ParseError) {
+	return p.parse(text, p.depth)
+}
+
+func (p decodingParser) parse(text string, depth int) ([]Var, []ParseError) {
+	vars, errs := p.Parser.Parse(text)
+	if depth == 0 {
+		return vars, errs
+	}
+	vars = decodeValues(vars)
+	for _, b := range decodeBlocks(text) {
+		vs, es := p.parse(normalizeText(b.text), depth-1)
+		if len(vs) == 0 {
+			continue // 解码结果不是变量文本，多半是误判，忽略其中的错误
+		}
+		log.Printf("🔓 解码%s内容得到 %d 个变量", b.kind, len(vs))
+		if b.line > 0 {
+			for i := range vs {
+				vs[i].Line = b.line
+			}
+		}
+		// 外层把编码块当作普通文本解析产生的错误不再有意义
+		errs = dropErrors(errs, b.line)
+		vars = mergeDecoded(vars, vs)
+		errs = append(errs, es...)
+	}
+	return vars, errs
+}
+
+// dropErrors 去掉第 line 行的解析错误，line 为 0 时去掉全部
+func dropErrors(errs []ParseError, line int) []ParseError {
+	if line == 0 {
+		return nil
+	}
+	var out []ParseError
+	for _, e := range errs {
+		if e.Line != line {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// decodeValues 解码整体为百分号编码的变量值
+func decodeValues(vars []Var) []Var {
+	for i, v := range vars {
+		if !percentEncoded(v.Value) {
+			continue
+		}
+		if s, ok := decodePercent(v.Value); ok {
+			log.Printf("🔓 解码变量 %s 的百分号编码值", v.Name)
+			vars[i].Value = s
+		}
+	}
+	return vars
+}
+
+func percentEncoded(s string) bool {
+	return strings.Contains(s, "%") && percentEncodedRegexp.MatchString(s)
+}
+
+type decodedBlock struct {
+	kind string
+	text string
+	line int // 编码块在外层文本中的行号，整段解码时为 0
+}
+
+// decodeBlocks 找出文本中可以解码为可读文本的编码块
+func decodeBlocks(text string) []decodedBlock {
+	if trimmed := strings.TrimSpace(text); strings.ContainsAny(trimmed, "\n ") && base64BlockRegexp.MatchString(trimmed) {
+		joined := strings.Join(strings.Fields(trimmed), "")
+		if s, ok := decodeBase64(joined); ok {
+			return []decodedBlock{{kind: "base64", text: s}}
+		}
+	}
+
+	var blocks []decodedBlock
+	for _, loc := range tokenRegexp.FindAllStringIndex(text, -1) {
+		if token := text[loc[0]:loc[1]]; percentEncoded(token) {
+			if s, ok := decodePercent(token); ok {
+				line := strings.Count(text[:loc[0]], "\n") + 1
+				blocks = append(blocks, decodedBlock{kind: "百分号编码", text: s, line: line})
+			}
+		}
+	}
+	for _, loc := range base64Regexp.FindAllStringIndex(text, -1) {
+		if s, ok := decodeBase64(text[loc[0]:loc[1]]); ok {
+			line := strings.Count(text[:loc[0]], "\n") + 1
+			blocks = append(blocks, decodedBlock{kind: "base64", text: s, line: line})
+		}
+	}
+	return blocks
+}
+
+// decodePercent 只替换连续的 %XX 序列，其余字符（包括 +）保持不变
+func decodePercent(text string) (string, bool) {
+	valid := true
+	out := percentRegexp.ReplaceAllStringFunc(text, func(run string) string {
+		b, err := hex.DecodeString(strings.ReplaceAll(run, "%", ""))
+		if err != nil || !utf8.Valid(b) {
+			valid = false
+			return run
+		}
+		return string(b)
+	})
+	return out, valid && out != text && readable(out)
+}
+
+func decodeBase64(s string) (string, bool) {
+	if len(s) < minBase64Len {
+		return "", false
+	}
+	for _, enc := range base64Encodings {
+		data, err := enc.DecodeString(s)
+		if err != nil {
+			continue
+		}
+		if text := string(data); utf8.Valid(data) && readable(text) {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// readable 解码结果须是可读文本，排除把普通 ID 误当成 base64 解出的乱码
+func readable(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDecoded 解码出的变量替换外层同名变量，新变量追加在后面
+// 外层的值已经是有效链接时不替换，避免把链接中正确编码的参数解码后写坏
+func mergeDecoded(vars, decoded []Var) []Var {
+	for _, d := range decoded {
+		replaced := false
+		for i := range vars {
+			if vars[i].Name == d.Name {
+				if !validURL(vars[i].Value) {
+					vars[i] = d
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			vars = append(vars, d)
+		}
+	}
+	return vars
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodingParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser Parser
+		text   string
+		vars   []Var
+	}{
+		{
+			name:   "base64 行不保留外层错误",
+			parser: DotenvParser{},
+			text:   "ZXhwb3J0IGpkX3Rlc3Q9ImFiYyI=", // export jd_test="abc"
+			vars:   []Var{{Name: "jd_test", Value: "abc", Line: 1}},
+		},
+		{
+			name:   "按行折断的 base64",
+			parser: ShellParser{},
+			text:   "ZXhwb3J0IEE9MSBC\nPTI=", // export A=1 B=2
+			vars:   []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "2", Line: 1}},
+		},
+		{
+			name:   "整体编码的值",
+			parser: ShellParser{},
+			text:   "export A=https%3A%2F%2Fa.com%2F%3Fid%3D1",
+			vars:   []Var{{Name: "A", Value: "https://a.com/?id=1", Line: 1}},
+		},
+		{
+			name:   "链接中编码的参数保持不变",
+			parser: ShellParser{},
+			text:   "export r=https://a.com/?r=https%3A%2F%2Fb.com%2F%3Fx%3D1%26y%3D2",
+			vars:   []Var{{Name: "r", Value: "https://a.com/?r=https%3A%2F%2Fb.com%2F%3Fx%3D1%26y%3D2", Line: 1}},
+		},
+		{
+			name:   "部分编码的 cookie 保持不变",
+			parser: ShellParser{},
+			text:   "export JD_COOKIE=\"pt_key=abc;pt_pin=%E5%BC%A0%E4%B8%89;\"",
+			vars:   []Var{{Name: "JD_COOKIE", Value: "pt_key=abc;pt_pin=%E5%BC%A0%E4%B8%89;", Line: 1}},
+		},
+		{
+			name:   "整段百分号编码",
+			parser: ShellParser{},
+			text:   "变量：\n%65%78%70%6F%72%74%20%41%3D%31",
+			vars:   []Var{{Name: "A", Value: "1", Line: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newDecodingParser(tt.parser, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vars, errs := p.Parse(tt.text)
+			if !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("vars = %+v, want %+v", vars, tt.vars)
+			}
+			if len(errs) != 0 {
+				t.Errorf("errs = %v", errs)
+			}
+		})
+	}
+}
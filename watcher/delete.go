@@ -0,0 +1,33 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-env-watcher/ql"
+)
+
+// handleDelete 来源消息被删除后，按目标设置恢复或禁用这些消息写入的变量
+func handleDelete(ctx context.Context, router *ql.Router, store *messageStore, tgt *target, peerID int64, ids []int) error {
+	var b strings.Builder
+	for _, id := range store.remove(peerID, ids) {
+		lines, err := router.Revert(ctx, peerID, id, tgt.onDelete)
+		if err != nil {
+			log.Printf("❌ 撤销消息 #%d 写入的变量失败: %v", id, err)
+			lines = append(lines, fmt.Sprintf("❗撤销失败: %v", err))
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		for _, l := range lines {
+			log.Printf("🗑 消息 #%d 已删除，%s", id, l)
+		}
+		writeSection(&b, fmt.Sprintf("🗑 @%s 删除了消息 #%d，已撤销变量:", tgt.username, id), lines)
+	}
+	if b.Len() > 0 {
+		ql.SendNotifyViaQL("📥 青龙处理结果通知", b.String())
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+
+	"telegram-env-watcher/utils"
+)
+
+const defaultDocumentMaxSize = 1 << 20
+
+var (
+	defaultDocumentMimeTypes  = []string{"text/*", "application/json", "application/x-sh", "application/x-yaml", "application/yaml"}
+	defaultDocumentExtensions = []string{".sh", ".txt", ".env", ".json", ".yaml", ".yml", ".conf"}
+)
+
+var errDocumentTooLarge = errors.New("附件超过大小限制")
+
+// messageDocument 返回消息中的文档附件，没有时返回 nil
+func messageDocument(msg *tg.Message) *tg.Document {
+	media, ok := msg.Media.(*tg.MessageMediaDocument)
+	if !ok {
+		return nil
+	}
+	doc, _ := media.Document.(*tg.Document)
+	return doc
+}
+
+func documentName(doc *tg.Document) string {
+	for _, attr := range doc.Attributes {
+		if a, ok := attr.(*tg.DocumentAttributeFilename); ok {
+			return a.FileName
+		}
+	}
+	return fmt.Sprintf("document_%d", doc.ID)
+}
+
+// documentAllowed 按扩展名或 MIME 类型判断附件是否需要解析，任一命中即可
+func documentAllowed(cfg utils.DocumentConfig, name, mime string) bool {
+	exts := cfg.Extensions
+	if len(exts) == 0 {
+		exts = defaultDocumentExtensions
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range exts {
+		if ext != "" && strings.EqualFold(strings.TrimSpace(e), ext) {
+			return true
+		}
+	}
+
+	mimes := cfg.MimeTypes
+	if len(mimes) == 0 {
+		mimes = defaultDocumentMimeTypes
+	}
+	mime = strings.ToLower(mime)
+	for _, m := range mimes {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(m)), mime); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// documentText 返回消息中允许解析的文本附件内容，没有附件或未启用时返回空字符串
+func documentText(ctx context.Context, client *telegram.Client, cfg *utils.Config, msg *tg.Message) (string, string, error) {
+	doc := messageDocument(msg)
+	if doc == nil || !cfg.Documents.Enabled {
+		return "", "", nil
+	}
+	name := documentName(doc)
+	if !documentAllowed(cfg.Documents, name, doc.MimeType) {
+		if cfg.Debug {
+			log.Printf("📎 忽略附件 %s（%s）", name, doc.MimeType)
+		}
+		return name, "", nil
+	}
+	log.Printf("📎 下载附件 %s（%d 字节）", name, doc.Size)
+	text, err := downloadDocument(ctx, client, cfg.Documents, doc)
+	return name, text, err
+}
+
+// downloadDocument 下载文本附件内容
+func downloadDocument(ctx context.Context, client *telegram.Client, cfg utils.DocumentConfig, doc *tg.Document) (string, error) {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultDocumentMaxSize
+	}
+	if doc.Size > maxSize {
+		return "", fmt.Errorf("%w（%d > %d 字节）", errDocumentTooLarge, doc.Size, maxSize)
+	}
+
+	buf := &limitedBuffer{max: maxSize}
+	if _, err := downloader.NewDownloader().Download(client.API(), doc.AsInputDocumentFileLocation()).Stream(ctx, buf); err != nil {
+		return "", err
+	}
+	data := bytes.TrimPrefix(buf.Bytes(), []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("附件不是 UTF-8 文本")
+	}
+	return string(data), nil
+}
+
+// limitedBuffer 超过上限时返回错误，防止 Size 与实际内容不符
+type limitedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.max {
+		return 0, errDocumentTooLarge
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,175 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+
+	"telegram-env-watcher/ql"
+	"telegram-env-watcher/utils"
+)
+
+// 最多记录的消息数，超出后丢弃最早的记录
+const maxMessageRecords = 1000
+
+type messageKey struct {
+	peer int64
+	id   int
+}
+
+// messageRecord 一条消息（或聚合后的一组消息）及其解析出的变量
+type messageRecord struct {
+	tgt  *target
+	peer string
+	msgs []*tg.Message
+	vars []Var
+}
+
+// messageStore 记录最近处理过的消息，用于处理编辑
+type messageStore struct {
+	mu      sync.Mutex
+	records map[messageKey]*messageRecord
+	order   []messageKey
+}
+
+func newMessageStore() *messageStore {
+	return &messageStore{records: make(map[messageKey]*messageRecord)}
+}
+
+// put 记录一组消息，组内每条消息都指向同一条记录
+func (s *messageStore) put(peerID int64, tgt *target, peer string, msgs []*tg.Message, vars []Var) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := &messageRecord{tgt: tgt, peer: peer, msgs: msgs, vars: vars}
+	for _, m := range msgs {
+		key := messageKey{peerID, m.ID}
+		if _, ok := s.records[key]; !ok {
+			s.order = append(s.order, key)
+		}
+		s.records[key] = rec
+	}
+	for len(s.order) > maxMessageRecords {
+		delete(s.records, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// edit 用编辑后的消息替换记录中的旧消息，返回替换后的消息组和原有变量
+// 没有记录或内容未变化（如仅更新了表情回应）时 ok 为 false
+func (s *messageStore) edit(peerID int64, msg *tg.Message) (rec messageRecord, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, found := s.records[messageKey{peerID, msg.ID}]
+	if !found {
+		return rec, false
+	}
+	msgs := make([]*tg.Message, len(r.msgs))
+	copy(msgs, r.msgs)
+	for i, m := range msgs {
+		if m.ID == msg.ID {
+			if m.Message == msg.Message && m.EditDate == msg.EditDate {
+				return rec, false
+			}
+			msgs[i] = msg
+		}
+	}
+	return messageRecord{tgt: r.tgt, peer: r.peer, msgs: msgs, vars: r.vars}, true
+}
+
+// update 保存编辑后重新解析的结果
+func (s *messageStore) update(peerID int64, msgs []*tg.Message, vars []Var) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		if r, ok := s.records[messageKey{peerID, m.ID}]; ok {
+			r.msgs, r.vars = msgs, vars
+		}
+	}
+}
+
+// remove 删除消息对应的记录，返回来源消息 ID（聚合的一组消息以第一条为准）
+// 没有记录时原样返回消息 ID，仍可按历史撤销重启前处理的消息
+func (s *messageStore) remove(peerID int64, ids []int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []int
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		src := id
+		if r, ok := s.records[messageKey{peerID, id}]; ok {
+			src = r.msgs[0].ID
+			for _, m := range r.msgs {
+				delete(s.records, messageKey{peerID, m.ID})
+			}
+		}
+		if !seen[src] {
+			seen[src] = true
+			out = append(out, src)
+		}
+	}
+	return out
+}
+
+// diffVars 比较编辑前后的变量，返回新增或值有变化的变量，以及编辑后消失的变量名
+func diffVars(before, after []Var) (changed []Var, removed []string, lines []string) {
+	old := make(map[string]string)
+	for _, v := range before {
+		old[v.Name] = v.Value
+	}
+	now := make(map[string]bool)
+	for _, v := range after {
+		now[v.Name] = true
+		prev, ok := old[v.Name]
+		switch {
+		case !ok:
+			changed = append(changed, v)
+			lines = append(lines, fmt.Sprintf("%s: 新增 %s", v.Name, v.Value))
+		case prev != v.Value:
+			changed = append(changed, v)
+			lines = append(lines, fmt.Sprintf("%s: %s → %s", v.Name, prev, v.Value))
+		}
+	}
+	for _, v := range before {
+		if !now[v.Name] {
+			now[v.Name] = true
+			removed = append(removed, v.Name)
+			lines = append(lines, fmt.Sprintf("%s: 已从消息中删除，青龙中的值保持不变", v.Name))
+		}
+	}
+	return changed, removed, lines
+}
+
+// handleEdit 重新解析被编辑的消息，只写入值有变化的变量
+func handleEdit(ctx context.Context, client *telegram.Client, router *ql.Router, cfg *utils.Config, store *messageStore, peerID int64, msg *tg.Message) error {
+	rec, ok := store.edit(peerID, msg)
+	if !ok {
+		if cfg.Debug {
+			log.Printf("✏️ 消息 #%d 被编辑，但没有处理记录或内容未变化，忽略", msg.ID)
+		}
+		return nil
+	}
+
+	vars, errs := extractVars(ctx, client, cfg, rec.tgt, rec.msgs)
+	store.update(peerID, rec.msgs, vars)
+	changed, removed, lines := diffVars(rec.vars, vars)
+	for _, l := range lines {
+		log.Printf("✏️ 消息 #%d 编辑后变量变化: %s", msg.ID, l)
+	}
+	if len(changed) == 0 {
+		if len(removed) == 0 {
+			log.Printf("✏️ 消息 #%d 编辑后变量没有变化", msg.ID)
+		}
+		return nil
+	}
+
+	rep := newReport(len(router.Clients()) > 1)
+	rep.changes = lines
+	rep.errs = append(rep.errs, errs...)
+	// 与首次处理使用相同的消息 ID，删除消息时才能找到编辑后写入的记录
+	src := ql.NewSource(peerID, rec.tgt.username, rec.peer, rec.msgs[0].ID, messagesText(rec.msgs))
+	return applyVars(ctx, router, rec.tgt, rep, changed, src)
+}
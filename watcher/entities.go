@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// EntityMode 按消息实体提取变量的方式
+type EntityMode string
+
+const (
+	EntityAll    EntityMode = "all"    // 解析整条消息（默认）
+	EntityOnly   EntityMode = "only"   // 只解析代码、预格式和剧透块
+	EntityPrefer EntityMode = "prefer" // 优先解析这些块，没有结果时退回整条消息
+)
+
+func ParseEntityMode(s string) (EntityMode, error) {
+	switch m := EntityMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return EntityAll, nil
+	case EntityAll, EntityOnly, EntityPrefer:
+		return m, nil
+	default:
+		return EntityAll, fmt.Errorf("未知的实体提取方式: %s", s)
+	}
+}
+
+// parseEntities 按目标设置选择解析的文本范围，多条消息（相册或连续消息）合并为一段文本解析
+func parseEntities(p Parser, msgs []*tg.Message, mode EntityMode) ([]Var, []ParseError) {
+	var texts, blocks []string
+	for _, m := range msgs {
+		if m.Message == "" {
+			continue
+		}
+		texts = append(texts, m.Message)
+		if s := entityText(m.Message, m.Entities); s != "" {
+			blocks = append(blocks, s)
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if mode == EntityAll || mode == "" {
+		return p.Parse(text)
+	}
+
+	spans := strings.Join(blocks, "\n")
+	if spans != "" {
+		vars, errs := p.Parse(spans)
+		if len(vars) > 0 || mode == EntityOnly {
+			return vars, errs
+		}
+	} else if mode == EntityOnly {
+		return nil, nil
+	}
+	return p.Parse(text)
+}
+
+type span struct{ start, end int }
+
+// entityText 取出消息中代码、预格式和剧透块的内容，每块单独成行
+// Telegram 实体的偏移和长度以 UTF-16 码元计算
+func entityText(text string, entities []tg.MessageEntityClass) string {
+	var spans []span
+	for _, e := range entities {
+		switch e.(type) {
+		case *tg.MessageEntityCode, *tg.MessageEntityPre, *tg.MessageEntitySpoiler:
+			spans = append(spans, span{e.GetOffset(), e.GetOffset() + e.GetLength()})
+		}
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+
+	// 合并重叠的块（如剧透中包含代码），避免重复解析
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start <= last.end {
+			if s.end > last.end {
+				last.end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	units := utf16.Encode([]rune(text))
+	var parts []string
+	for _, s := range merged {
+		if s.start < 0 || s.start >= len(units) {
+			continue
+		}
+		if s.end > len(units) {
+			s.end = len(units)
+		}
+		parts = append(parts, string(utf16.Decode(units[s.start:s.end])))
+	}
+	return strings.Join(parts, "\n")
+}
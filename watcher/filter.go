@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// varPattern 变量名匹配规则：默认为 * ? 通配符，写成 /.../ 时为正则
+type varPattern struct {
+	raw  string
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePatterns(list []string) ([]varPattern, error) {
+	var out []varPattern
+	for _, raw := range list {
+		p := strings.TrimSpace(raw)
+		if p == "" {
+			continue
+		}
+		if len(p) >= 2 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("变量名正则 %s 无效: %w", p, err)
+			}
+			out = append(out, varPattern{raw: p, re: re})
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("变量名通配符 %s 无效: %w", p, err)
+		}
+		out = append(out, varPattern{raw: p, glob: p})
+	}
+	return out, nil
+}
+
+func (p varPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// varFilter 变量名的允许和禁止列表，禁止优先；允许列表为空时不限制
+type varFilter struct {
+	allow []varPattern
+	deny  []varPattern
+}
+
+func compileVarFilter(allow, deny []string) (*varFilter, error) {
+	a, err := compilePatterns(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := compilePatterns(deny)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 && len(d) == 0 {
+		return nil, nil
+	}
+	return &varFilter{allow: a, deny: d}, nil
+}
+
+// check 返回变量被拦截的原因，允许写入时返回空字符串
+func (f *varFilter) check(name string) string {
+	if f == nil {
+		return ""
+	}
+	for _, p := range f.deny {
+		if p.match(name) {
+			return "命中禁止规则 " + p.raw
+		}
+	}
+	if len(f.allow) == 0 {
+		return ""
+	}
+	for _, p := range f.allow {
+		if p.match(name) {
+			return ""
+		}
+	}
+	return "不在允许列表中"
+}
+
+// checkName 依次按各个规则检查变量名，返回第一个拦截原因
+func checkName(name string, filters ...*varFilter) string {
+	for _, f := range filters {
+		if reason := f.check(name); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// filterVars 依次按全局和目标的规则过滤变量，返回允许写入的变量和被拦截的说明
+func filterVars(vars []Var, filters ...*varFilter) ([]Var, []string) {
+	var allowed []Var
+	var rejected []string
+	for _, v := range vars {
+		if reason := checkName(v.Name, filters...); reason != "" {
+			rejected = append(rejected, fmt.Sprintf("%s（%s）", v.Name, reason))
+			continue
+		}
+		allowed = append(allowed, v)
+	}
+	return allowed, rejected
+}
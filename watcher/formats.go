@@ -0,0 +1,280 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseFormat 按名称选择解析器：shell（默认）、auto、dotenv、json、yaml
+func ParseFormat(s string) (Parser, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "shell", "export":
+		return ShellParser{}, nil
+	case "auto":
+		return AutoParser{}, nil
+	case "dotenv", "env":
+		return DotenvParser{}, nil
+	case "json":
+		return JSONParser{}, nil
+	case "yaml", "yml":
+		return YAMLParser{}, nil
+	default:
+		return nil, fmt.Errorf("未知的消息格式: %s", s)
+	}
+}
+
+// AutoParser 依次尝试 shell、JSON、dotenv、YAML，使用第一个解析出变量的结果
+//
+// dotenv 和 YAML 几乎能匹配任意 "键=值"、"键: 值" 的聊天文字，只有在每个非空行
+// 都是一个变量且没有错误时才采用；单行文本不按 YAML 解析，避免把 "Note: 今晚8点开抢"
+// 之类的内容写入青龙。
+type AutoParser struct{}
+
+func (AutoParser) Parse(text string) ([]Var, []ParseError) {
+	var errs []ParseError
+	for _, p := range []Parser{ShellParser{}, JSONParser{}} {
+		vars, perrs := p.Parse(text)
+		if len(vars) > 0 {
+			return vars, perrs
+		}
+		errs = append(errs, perrs...)
+	}
+	lines := contentLines(text)
+	whole := []Parser{DotenvParser{}}
+	if lines > 1 {
+		whole = append(whole, YAMLParser{})
+	}
+	for _, p := range whole {
+		if vars, perrs := p.Parse(text); len(vars) == lines && len(perrs) == 0 && lines > 0 {
+			return vars, nil
+		}
+	}
+	return nil, errs
+}
+
+// contentLines 统计非空且不是 # 注释的行数
+func contentLines(text string) int {
+	n := 0
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			n++
+		}
+	}
+	return n
+}
+
+var dotenvLineRegexp = regexp.MustCompile(`^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*=(.*)$`)
+
+// DotenvParser 解析 KEY=VALUE 形式的行，export 前缀可选
+//
+// 值支持单引号、双引号（\n \" \\ 转义）和未加引号的写法，未加引号时 " #" 之后视为注释。
+type DotenvParser struct{}
+
+func (DotenvParser) Parse(text string) ([]Var, []ParseError) {
+	var vars []Var
+	var errs []ParseError
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		m := dotenvLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		value, err := dotenvValue(strings.TrimSpace(m[2]))
+		if err == nil && value == "" {
+			err = fmt.Errorf("变量 %s 的值为空", m[1])
+		}
+		if err != nil {
+			errs = append(errs, ParseError{Line: i + 1, Text: strings.TrimSpace(line), Err: err.Error()})
+			continue
+		}
+		vars = append(vars, Var{Name: m[1], Value: value, Line: i + 1})
+	}
+	return vars, errs
+}
+
+func dotenvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("单引号未闭合")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch c := raw[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(raw[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("双引号未闭合")
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// JSONParser 提取消息中的 JSON 对象，每个顶层键作为一个变量
+//
+// 消息中可以夹杂其它文字，值必须是字符串、数字或布尔值。
+type JSONParser struct{}
+
+func (JSONParser) Parse(text string) ([]Var, []ParseError) {
+	var vars []Var
+	var errs []ParseError
+	for pos := 0; pos < len(text); {
+		i := strings.IndexByte(text[pos:], '{')
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		line := strings.Count(text[:start], "\n") + 1
+		pos = start + 1
+
+		// 只处理以 {" 开头的片段，避免把普通文字里的花括号当作 JSON
+		if rest := strings.TrimLeft(text[pos:], " \t\r\n"); !strings.HasPrefix(rest, `"`) {
+			continue
+		}
+
+		dec := json.NewDecoder(strings.NewReader(text[start:]))
+		dec.UseNumber()
+		var obj map[string]json.RawMessage
+		if err := dec.Decode(&obj); err != nil {
+			errs = append(errs, ParseError{Line: line, Text: firstLine(text[start:]), Err: "JSON 格式错误: " + err.Error()})
+			continue
+		}
+		pos = start + int(dec.InputOffset())
+
+		// 按原始顺序输出，map 遍历顺序不固定
+		for _, key := range jsonKeys(text[start:pos]) {
+			raw, ok := obj[key]
+			if !ok {
+				continue
+			}
+			v, err := jsonVar(key, raw)
+			if err != nil {
+				errs = append(errs, ParseError{Line: line, Text: firstLine(text[start:]), Err: err.Error()})
+				continue
+			}
+			v.Line = line
+			vars = append(vars, v)
+			delete(obj, key)
+		}
+	}
+	return vars, errs
+}
+
+func jsonVar(key string, raw json.RawMessage) (Var, error) {
+	if !varNameRegexp.MatchString(key) {
+		return Var{}, fmt.Errorf("变量名 %q 不合法", key)
+	}
+	var value interface{}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return Var{}, err
+	}
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = fmt.Sprint(v)
+	default:
+		return Var{}, fmt.Errorf("变量 %s 的值不是字符串或数字", key)
+	}
+	if strings.TrimSpace(s) == "" {
+		return Var{}, fmt.Errorf("变量 %s 的值为空", key)
+	}
+	return Var{Name: key, Value: s}, nil
+}
+
+// jsonKeys 按出现顺序返回对象的顶层键
+func jsonKeys(object string) []string {
+	dec := json.NewDecoder(strings.NewReader(object))
+	if _, err := dec.Token(); err != nil { // {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := t.(string)
+		keys = append(keys, key)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
+
+// YAMLParser 将整段消息按 YAML 映射解析，每个顶层键作为一个变量
+type YAMLParser struct{}
+
+func (YAMLParser) Parse(text string) ([]Var, []ParseError) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, []ParseError{{Err: "YAML 格式错误: " + err.Error()}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	lines := strings.Split(text, "\n")
+	var vars []Var
+	var errs []ParseError
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, val := m.Content[i], m.Content[i+1]
+		pe := ParseError{Line: key.Line}
+		if key.Line > 0 && key.Line <= len(lines) {
+			pe.Text = strings.TrimSpace(lines[key.Line-1])
+		}
+		switch {
+		case !varNameRegexp.MatchString(key.Value):
+			pe.Err = fmt.Sprintf("变量名 %q 不合法", key.Value)
+		case val.Kind != yaml.ScalarNode:
+			pe.Err = fmt.Sprintf("变量 %s 的值不是字符串或数字", key.Value)
+		case strings.TrimSpace(val.Value) == "":
+			pe.Err = fmt.Sprintf("变量 %s 的值为空", key.Value)
+		default:
+			vars = append(vars, Var{Name: key.Value, Value: val.Value, Line: key.Line})
+			continue
+		}
+		errs = append(errs, pe)
+	}
+	return vars, errs
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAutoParser(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		vars []Var
+	}{
+		{
+			name: "shell",
+			text: `export A="1"`,
+			vars: []Var{{Name: "A", Value: "1", Line: 1}},
+		},
+		{
+			name: "JSON",
+			text: `{"A": "1"}`,
+			vars: []Var{{Name: "A", Value: "1", Line: 1}},
+		},
+		{
+			name: "整段 dotenv",
+			text: "A=1\n# 注释\nB=2",
+			vars: []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "2", Line: 3}},
+		},
+		{
+			name: "整段 YAML",
+			text: "A: 1\nB: x",
+			vars: []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "x", Line: 2}},
+		},
+		{
+			name: "单行聊天文字不按 YAML 解析",
+			text: "Note: 今晚8点开抢",
+		},
+		{
+			name: "单行时间不按 YAML 解析",
+			text: "Time: 20:00",
+		},
+		{
+			name: "夹杂聊天文字的 dotenv",
+			text: "活动开始了\nA=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, _ := AutoParser{}.Parse(tt.text)
+			if !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("vars = %+v，期望 %+v", vars, tt.vars)
+			}
+		})
+	}
+}
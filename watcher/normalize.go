@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 只处理以分号结尾的 HTML 实体，避免把 a&notify 之类的多值变量误当成 &not
+var htmlEntityRegexp = regexp.MustCompile(`&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+
+// quoteReplacer 把排版引号和相似字符统一为 ASCII 引号
+var quoteReplacer = strings.NewReplacer(
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`, "«", `"`, "»", `"`, "〝", `"`, "〞", `"`,
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'", "´", "'",
+)
+
+// normalizeText 清理频道为防止抓取而加入的干扰字符：
+// 解码 HTML 实体，统一引号，NFKC 归一化（全角符号转半角），去除零宽等不可见字符
+func normalizeText(text string) string {
+	text = htmlEntityRegexp.ReplaceAllStringFunc(text, html.UnescapeString)
+	// 引号先于 NFKC 替换，否则 ″ ´ 会被分解成两个字符
+	text = quoteReplacer.Replace(text)
+	text = norm.NFKC.String(text)
+	return strings.Map(func(r rune) rune {
+		// Cf 包含零宽空格、零宽连接符、BOM、双向控制符和软连字符
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// normalizedParser 解析前先归一化文本
+// 实体偏移基于原始文本，因此归一化放在解析器中，在按实体截取之后进行
+type normalizedParser struct {
+	Parser
+}
+
+func (p normalizedParser) Parse(text string) ([]Var, []ParseError) {
+	return p.Parser.Parse(normalizeText(text))
+}
+
+// logNormalized 调试模式下对照输出原始文本和归一化后的文本
+func logNormalized(label, text string) {
+	if normalized := normalizeText(text); normalized != text {
+		log.Printf("🧹 %s归一化\n原始: %q\n结果: %q", label, text, normalized)
+	}
+}
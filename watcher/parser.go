@@ -0,0 +1,233 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Var 从消息中解析出的变量
+type Var struct {
+	Name  string
+	Value string
+	Line  int // 所在行号，从 1 开始
+}
+
+// ParseError 单行解析失败的原因
+type ParseError struct {
+	Line int
+	Text string
+	Err  string
+}
+
+func (e ParseError) String() string {
+	if e.Line == 0 {
+		return e.Err
+	}
+	return fmt.Sprintf("第 %d 行 %q: %s", e.Line, e.Text, e.Err)
+}
+
+// Parser 从消息文本中提取变量
+type Parser interface {
+	Parse(text string) ([]Var, []ParseError)
+}
+
+var varNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ShellParser 按 POSIX shell 语法解析 export 语句
+//
+// 支持单引号、双引号、反斜杠转义、未加引号的值、一行多个赋值（export A=1 B=2）、
+// 引号内跨行的值、行首缩进以及 ; && 分隔的多条命令。不做变量展开，$ 原样保留；
+// 与 shell 不同，词中未加引号的 & 按字面处理，以兼容 a&b&c 形式的多值变量。
+type ShellParser struct{}
+
+func (ShellParser) Parse(text string) ([]Var, []ParseError) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	// 整段文本共用一个词法分析器，逐行前进，避免每个 export 都重新拼接剩余文本
+	lx := &shellLexer{src: text}
+	var vars []Var
+	var errs []ParseError
+	for pos, line := 0, 1; pos < len(text); {
+		end := strings.IndexByte(text[pos:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += pos
+		}
+		raw := text[pos:end]
+		next := min(end+1, len(text))
+
+		trimmed := strings.TrimLeft(raw, " \t")
+		rest := strings.TrimPrefix(trimmed, "export")
+		// 跳过 exportXXX 之类的普通文本
+		if len(rest) < len(trimmed) && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			// 引号或续行可能跨越后续行
+			lx.pos = pos + len(raw) - len(trimmed)
+			stop, cmdErrs := parseExportCommands(lx, line, &vars)
+			for _, e := range cmdErrs {
+				e.Text = strings.TrimSpace(raw)
+				errs = append(errs, e)
+			}
+			if stop > next {
+				next = stop
+			}
+		}
+		line += strings.Count(text[pos:next], "\n")
+		pos = next
+	}
+	return vars, errs
+}
+
+// parseExportCommands 解析从 export 开始的一组命令（直到未转义的换行），返回结束位置
+// 出错时返回 -1，不消耗后续行，避免一个未闭合的引号吞掉后面的内容
+func parseExportCommands(lx *shellLexer, line int, vars *[]Var) (int, []ParseError) {
+	var errs []ParseError
+	for {
+		words, end, err := lx.command()
+		if err != nil {
+			errs = append(errs, ParseError{Line: line, Err: err.Error()})
+			return -1, errs
+		}
+		if len(words) > 0 && words[0].text == "export" {
+			for _, w := range words[1:] {
+				if strings.HasPrefix(w.text, "-") && w.eq < 0 {
+					continue // export -n 等参数
+				}
+				v, err := w.assignment()
+				if err != nil {
+					errs = append(errs, ParseError{Line: line, Err: err.Error()})
+					continue
+				}
+				v.Line = line
+				*vars = append(*vars, v)
+			}
+		}
+		if end != ';' {
+			return lx.pos, errs
+		}
+	}
+}
+
+// shellWord 一个分词结果，eq 为第一个未加引号的 = 在 text 中的位置
+type shellWord struct {
+	text string
+	eq   int
+}
+
+func (w shellWord) assignment() (Var, error) {
+	if w.eq < 0 {
+		return Var{}, fmt.Errorf("%s 缺少赋值", w.text)
+	}
+	name, value := w.text[:w.eq], w.text[w.eq+1:]
+	if !varNameRegexp.MatchString(name) {
+		return Var{}, fmt.Errorf("变量名 %q 不合法", name)
+	}
+	if strings.TrimSpace(value) == "" {
+		return Var{}, fmt.Errorf("变量 %s 的值为空", name)
+	}
+	return Var{Name: name, Value: value}, nil
+}
+
+type shellLexer struct {
+	src string
+	pos int
+}
+
+// command 读取一条简单命令，返回分词和结束符（'\n'、';' 或 0 表示文本结束）
+func (lx *shellLexer) command() ([]shellWord, byte, error) {
+	var words []shellWord
+	for {
+		// 跳过空白
+		for lx.pos < len(lx.src) && (lx.src[lx.pos] == ' ' || lx.src[lx.pos] == '\t') {
+			lx.pos++
+		}
+		if lx.pos >= len(lx.src) {
+			return words, 0, nil
+		}
+		switch c := lx.src[lx.pos]; c {
+		case '\n':
+			lx.pos++
+			return words, '\n', nil
+		case ';', '&', '|':
+			// ; && || | 都视为命令分隔
+			lx.pos++
+			for lx.pos < len(lx.src) && (lx.src[lx.pos] == '&' || lx.src[lx.pos] == '|') {
+				lx.pos++
+			}
+			return words, ';', nil
+		case '#':
+			// 注释到行尾
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.pos++
+			}
+			continue
+		}
+		w, err := lx.word()
+		if err != nil {
+			return words, 0, err
+		}
+		words = append(words, w)
+	}
+}
+
+// word 读取一个词，处理引号与转义
+func (lx *shellLexer) word() (shellWord, error) {
+	var b strings.Builder
+	eq := -1
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch c {
+		case ' ', '\t', '\n', ';':
+			// & | 只在词首作为分隔符，词中的 & 按字面处理，兼容未加引号的 a&b 多值写法
+			return shellWord{b.String(), eq}, nil
+		case '\\':
+			lx.pos++
+			if lx.pos >= len(lx.src) {
+				return shellWord{b.String(), eq}, nil
+			}
+			if lx.src[lx.pos] != '\n' { // 反斜杠换行为续行
+				b.WriteByte(lx.src[lx.pos])
+			}
+			lx.pos++
+		case '\'':
+			end := strings.IndexByte(lx.src[lx.pos+1:], '\'')
+			if end < 0 {
+				return shellWord{}, fmt.Errorf("单引号未闭合")
+			}
+			b.WriteString(lx.src[lx.pos+1 : lx.pos+1+end])
+			lx.pos += end + 2
+		case '"':
+			lx.pos++
+			closed := false
+			for lx.pos < len(lx.src) && !closed {
+				d := lx.src[lx.pos]
+				switch {
+				case d == '"':
+					closed = true
+				case d == '\\' && lx.pos+1 < len(lx.src) && strings.IndexByte("$`\"\\\n", lx.src[lx.pos+1]) >= 0:
+					lx.pos++
+					if lx.src[lx.pos] != '\n' {
+						b.WriteByte(lx.src[lx.pos])
+					}
+				default:
+					b.WriteByte(d)
+				}
+				lx.pos++
+			}
+			if !closed {
+				return shellWord{}, fmt.Errorf("双引号未闭合")
+			}
+		case '=':
+			if eq < 0 {
+				eq = b.Len()
+			}
+			b.WriteByte(c)
+			lx.pos++
+		default:
+			b.WriteByte(c)
+			lx.pos++
+		}
+	}
+	return shellWord{b.String(), eq}, nil
+}
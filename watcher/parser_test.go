@@ -0,0 +1,114 @@
+package watcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellParser(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		vars []Var
+		errs int
+	}{
+		{
+			name: "未加引号",
+			text: "export JD_COOKIE=pt_key=abc;pt_pin=def",
+			vars: []Var{{Name: "JD_COOKIE", Value: "pt_key=abc", Line: 1}},
+		},
+		{
+			name: "单引号",
+			text: `export A='x y $z'`,
+			vars: []Var{{Name: "A", Value: "x y $z", Line: 1}},
+		},
+		{
+			name: "转义引号",
+			text: `export A="say \"hi\"" B=it\'s`,
+			vars: []Var{{Name: "A", Value: `say "hi"`, Line: 1}, {Name: "B", Value: "it's", Line: 1}},
+		},
+		{
+			name: "一行多个赋值",
+			text: "export A=1 B=2",
+			vars: []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "2", Line: 1}},
+		},
+		{
+			name: "行首缩进",
+			text: "活动变量：\n  \texport A=1\n    export B=2",
+			vars: []Var{{Name: "A", Value: "1", Line: 2}, {Name: "B", Value: "2", Line: 3}},
+		},
+		{
+			name: "多行值",
+			text: "export A=\"line1\nline2\"\nexport B='x\ny'\nexport C=3",
+			vars: []Var{
+				{Name: "A", Value: "line1\nline2", Line: 1},
+				{Name: "B", Value: "x\ny", Line: 3},
+				{Name: "C", Value: "3", Line: 5},
+			},
+		},
+		{
+			name: "分号和 && 分隔",
+			text: "export A=1; export B=2 && export C=3",
+			vars: []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "2", Line: 1}, {Name: "C", Value: "3", Line: 1}},
+		},
+		{
+			name: "& 连接的多值",
+			text: "export A=a&b&c",
+			vars: []Var{{Name: "A", Value: "a&b&c", Line: 1}},
+		},
+		{
+			name: "未闭合的引号不吞掉后续行",
+			text: "export A=\"abc\nexport B=2",
+			vars: []Var{{Name: "B", Value: "2", Line: 2}},
+			errs: 1,
+		},
+		{
+			name: "未闭合的单引号",
+			text: "export A='abc",
+			errs: 1,
+		},
+		{
+			name: "忽略普通文字",
+			text: "exporter=1\n说明 export 方法\nexport",
+		},
+		{
+			name: "CRLF 换行",
+			text: "export A=1\r\nexport B=2\r\n",
+			vars: []Var{{Name: "A", Value: "1", Line: 1}, {Name: "B", Value: "2", Line: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, errs := ShellParser{}.Parse(tt.text)
+			if !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("vars = %+v, want %+v", vars, tt.vars)
+			}
+			if len(errs) != tt.errs {
+				t.Errorf("errs = %v, want %d", errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestShellParserLargeInput(t *testing.T) {
+	var b strings.Builder
+	for b.Len() < 1<<20 {
+		b.WriteString("export JD_COOKIE=\"pt_key=abc;pt_pin=def\"\n")
+	}
+	text := b.String()
+	want := strings.Count(text, "\n")
+
+	start := time.Now()
+	vars, errs := ShellParser{}.Parse(text)
+	if len(vars) != want || len(errs) != 0 {
+		t.Fatalf("vars = %d, errs = %d, want %d vars", len(vars), len(errs), want)
+	}
+	if vars[want-1].Line != want {
+		t.Errorf("last line = %d, want %d", vars[want-1].Line, want)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("parsing 1 MiB took %v", d)
+	}
+}
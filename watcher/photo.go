@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+
+	"telegram-env-watcher/qrcode"
+	"telegram-env-watcher/utils"
+)
+
+const defaultPhotoMaxSize = 5 << 20
+
+// photoQRCodes 下载消息中的图片并识别其中的二维码，未启用或不是图片时返回 nil
+func photoQRCodes(ctx context.Context, client *telegram.Client, cfg *utils.Config, msg *tg.Message) ([]string, error) {
+	if !cfg.QRCode.Enabled {
+		return nil, nil
+	}
+	media, ok := msg.Media.(*tg.MessageMediaPhoto)
+	if !ok {
+		return nil, nil
+	}
+	photo, ok := media.Photo.(*tg.Photo)
+	if !ok {
+		return nil, nil
+	}
+	thumb, size := largestPhotoSize(photo)
+	if thumb == "" {
+		return nil, fmt.Errorf("图片没有可下载的尺寸")
+	}
+
+	maxSize := cfg.QRCode.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultPhotoMaxSize
+	}
+	if int64(size) > maxSize {
+		return nil, fmt.Errorf("%w（%d > %d 字节）", errDocumentTooLarge, size, maxSize)
+	}
+
+	loc := &tg.InputPhotoFileLocation{
+		ID:            photo.ID,
+		AccessHash:    photo.AccessHash,
+		FileReference: photo.FileReference,
+		ThumbSize:     thumb,
+	}
+	buf := &limitedBuffer{max: maxSize}
+	if _, err := downloader.NewDownloader().Download(client.API(), loc).Stream(ctx, buf); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("图片解码失败: %w", err)
+	}
+	return qrcode.Decode(img), nil
+}
+
+// largestPhotoSize 返回分辨率最高的尺寸类型及其字节数
+func largestPhotoSize(photo *tg.Photo) (string, int) {
+	var thumb string
+	var size, area int
+	for _, s := range photo.Sizes {
+		switch v := s.(type) {
+		case *tg.PhotoSize:
+			if v.W*v.H > area {
+				thumb, size, area = v.Type, v.Size, v.W*v.H
+			}
+		case *tg.PhotoSizeProgressive:
+			if v.W*v.H > area && len(v.Sizes) > 0 {
+				thumb, size, area = v.Type, v.Sizes[len(v.Sizes)-1], v.W*v.H
+			}
+		}
+	}
+	return thumb, size
+}
@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"telegram-env-watcher/utils"
+)
+
+// regexRule 一条编译后的正则提取规则
+type regexRule struct {
+	re    *regexp.Regexp
+	name  string // 变量名模板，支持 $group / ${group}
+	value string // 变量值模板
+}
+
+// RegexParser 按目标配置的正则规则提取变量
+//
+// 规则的命名分组可在模板中引用：未配置 name 时取 (?P<name>...) 分组，
+// 未配置 value 时取 (?P<value>...) 分组。
+type RegexParser struct {
+	rules []regexRule
+}
+
+func NewRegexParser(cfgs []utils.RegexRule) (*RegexParser, error) {
+	p := &RegexParser{}
+	for i, cfg := range cfgs {
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("规则 %d 正则无效: %w", i+1, err)
+		}
+		r := regexRule{re: re, name: cfg.Name, value: cfg.Value}
+		if r.name == "" {
+			if re.SubexpIndex("name") < 0 {
+				return nil, fmt.Errorf("规则 %d 未配置 name，且正则中没有 name 分组", i+1)
+			}
+			r.name = "${name}"
+		}
+		if r.value == "" {
+			if re.SubexpIndex("value") < 0 {
+				return nil, fmt.Errorf("规则 %d 未配置 value，且正则中没有 value 分组", i+1)
+			}
+			r.value = "${value}"
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+func (p *RegexParser) Parse(text string) ([]Var, []ParseError) {
+	var vars []Var
+	var errs []ParseError
+	for _, r := range p.rules {
+		for _, m := range r.re.FindAllStringSubmatchIndex(text, -1) {
+			line := strings.Count(text[:m[0]], "\n") + 1
+			name := string(r.re.ExpandString(nil, r.name, text, m))
+			value := strings.TrimSpace(string(r.re.ExpandString(nil, r.value, text, m)))
+			switch {
+			case !varNameRegexp.MatchString(name):
+				errs = append(errs, ParseError{Line: line, Text: firstLine(text[m[0]:m[1]]), Err: fmt.Sprintf("变量名 %q 不合法", name)})
+			case value == "":
+				errs = append(errs, ParseError{Line: line, Text: firstLine(text[m[0]:m[1]]), Err: fmt.Sprintf("变量 %s 的值为空", name)})
+			default:
+				vars = append(vars, Var{Name: name, Value: value, Line: line})
+			}
+		}
+	}
+	return vars, errs
+}
+
+// chainParser 依次运行多个解析器并合并结果，同名变量保留先出现的
+type chainParser []Parser
+
+func (c chainParser) Parse(text string) ([]Var, []ParseError) {
+	var vars []Var
+	var errs []ParseError
+	seen := make(map[string]bool)
+	for _, p := range c {
+		vs, es := p.Parse(text)
+		for _, v := range vs {
+			if !seen[v.Name] {
+				seen[v.Name] = true
+				vars = append(vars, v)
+			}
+		}
+		errs = append(errs, es...)
+	}
+	return vars, errs
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import "strings"
+
+// instanceReport 单个青龙实例的处理结果
+type instanceReport struct {
+	name        string
+	updatedVars []string
+	runScripts  []string
+	suppressed  []string
+	errs        []string
+}
+
+// report 一条消息的处理结果，按实例分别汇总
+type report struct {
+	multi     bool // 多实例时为每个实例加标题
+	instances []*instanceReport
+	byName    map[string]*instanceReport
+	errs      []string // 与具体实例无关的错误
+	changes   []string // 消息编辑前后的变量变化
+	rejected  []string // 被允许/禁止列表拦截的变量
+	invalid   []string // 值未通过校验的变量
+}
+
+func newReport(multi bool) *report {
+	return &report{multi: multi, byName: make(map[string]*instanceReport)}
+}
+
+func (r *report) instance(name string) *instanceReport {
+	if ir, ok := r.byName[name]; ok {
+		return ir
+	}
+	ir := &instanceReport{name: name}
+	r.byName[name] = ir
+	r.instances = append(r.instances, ir)
+	return ir
+}
+
+func (ir *instanceReport) write(b *strings.Builder) {
+	writeSection(b, "✅ 已更新以下环境变量:", ir.updatedVars)
+	writeSection(b, "🚀 已执行以下脚本:", ir.runScripts)
+	writeSection(b, "⏸ 以下脚本未触发:", ir.suppressed)
+	writeSection(b, "❗发生以下错误:", ir.errs)
+}
+
+func writeSection(b *strings.Builder, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(title + "\n")
+	for _, l := range lines {
+		b.WriteString("- " + l + "\n")
+	}
+}
+
+// String 构造最终通知消息
+func (r *report) String() string {
+	var b strings.Builder
+	writeSection(&b, "✏️ 消息已编辑，变量变化:", r.changes)
+	for _, ir := range r.instances {
+		if !r.multi {
+			ir.write(&b)
+			continue
+		}
+		var sub strings.Builder
+		ir.write(&sub)
+		if sub.Len() == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("🖥 [" + ir.name + "]\n" + sub.String())
+	}
+	writeSection(&b, "🚫 以下变量被拦截，未写入:", r.rejected)
+	writeSection(&b, "🚫 以下变量的值不合法，未写入:", r.invalid)
+	writeSection(&b, "❗发生以下错误:", r.errs)
+
+	if b.Len() == 0 {
+		return "⚠️ 未检测到变量或脚本更新"
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+
+	"telegram-env-watcher/utils"
+)
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `，。；！？）」】]+`)
+
+// ValidateURLRules 检查 URL 规则中的通配符和变量名
+func ValidateURLRules(rules []utils.URLRule) error {
+	for i, r := range rules {
+		for _, p := range []string{r.Host, r.Path} {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("URL 规则 %d 通配符无效 %q: %w", i+1, p, err)
+			}
+		}
+		if len(r.Params) == 0 && r.Var == "" {
+			return fmt.Errorf("URL 规则 %d 未配置 params 或 var", i+1)
+		}
+		for _, name := range r.Params {
+			if !varNameRegexp.MatchString(name) {
+				return fmt.Errorf("URL 规则 %d 变量名 %q 不合法", i+1, name)
+			}
+		}
+		if r.Var != "" && !varNameRegexp.MatchString(r.Var) {
+			return fmt.Errorf("URL 规则 %d 变量名 %q 不合法", i+1, r.Var)
+		}
+	}
+	return nil
+}
+
+// messageURLs 收集消息中的链接：内联按钮、文字链接、URL 实体以及正文中的裸链接
+func messageURLs(msg *tg.Message) []string {
+	var urls []string
+	if markup, ok := msg.ReplyMarkup.(*tg.ReplyInlineMarkup); ok {
+		for _, row := range markup.Rows {
+			for _, btn := range row.Buttons {
+				switch b := btn.(type) {
+				case *tg.KeyboardButtonURL:
+					urls = append(urls, b.URL)
+				case *tg.KeyboardButtonURLAuth:
+					urls = append(urls, b.URL)
+				case *tg.KeyboardButtonWebView:
+					urls = append(urls, b.URL)
+				case *tg.KeyboardButtonSimpleWebView:
+					urls = append(urls, b.URL)
+				}
+			}
+		}
+	}
+
+	var units []uint16
+	for _, e := range msg.Entities {
+		switch v := e.(type) {
+		case *tg.MessageEntityTextURL:
+			urls = append(urls, v.URL)
+		case *tg.MessageEntityURL:
+			if units == nil {
+				units = utf16.Encode([]rune(msg.Message))
+			}
+			if start, end := v.Offset, v.Offset+v.Length; start >= 0 && end <= len(units) && start < end {
+				urls = append(urls, string(utf16.Decode(units[start:end])))
+			}
+		}
+	}
+	return append(urls, textURLs(msg.Message)...)
+}
+
+// textURLs 提取文本中的裸链接，先归一化以去掉链接中夹杂的零宽字符
+func textURLs(text string) []string {
+	var urls []string
+	for _, u := range urlRegexp.FindAllString(normalizeText(text), -1) {
+		urls = append(urls, strings.TrimRight(u, ".,;:!?)]}"))
+	}
+	return urls
+}
+
+// urlVars 按规则把链接映射为变量，同一链接可命中多条规则
+func urlVars(rules []utils.URLRule, urls []string) []Var {
+	var vars []Var
+	seen := make(map[string]bool)
+	for _, raw := range urls {
+		if seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		query := u.Query()
+		for _, r := range rules {
+			if ok, _ := path.Match(strings.ToLower(r.Host), host); r.Host != "" && !ok {
+				continue
+			}
+			if ok, _ := path.Match(r.Path, u.Path); r.Path != "" && !ok {
+				continue
+			}
+			matched := false
+			// 按参数名排序，保证变量顺序稳定
+			params := make([]string, 0, len(r.Params))
+			for p := range r.Params {
+				params = append(params, p)
+			}
+			sort.Strings(params)
+			for _, p := range params {
+				if v := strings.TrimSpace(query.Get(p)); v != "" {
+					vars = append(vars, Var{Name: r.Params[p], Value: v})
+					matched = true
+				}
+			}
+			// 只配置 var 时匹配主机和路径即命中；同时配置 params 时需至少取到一个参数
+			if hit := matched || len(r.Params) == 0; hit {
+				if r.Var != "" {
+					vars = append(vars, Var{Name: r.Var, Value: raw})
+				}
+				log.Printf("🔗 链接命中 URL 规则: %s", raw)
+			}
+		}
+	}
+	return vars
+}
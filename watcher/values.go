@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"telegram-env-watcher/utils"
+)
+
+// 预置的字符类，也可以直接写正则字符类，如 [A-Za-z0-9_-]
+var charsets = map[string]string{
+	"digit": `[0-9]`,
+	"hex":   `[0-9a-fA-F]`,
+	"alnum": `[A-Za-z0-9]`,
+	"word":  `[A-Za-z0-9_\-]`,
+	"url":   `[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]`,
+}
+
+// valueRule 编译后的变量值规则
+type valueRule struct {
+	name        varPattern
+	transform   []string
+	pattern     *regexp.Regexp
+	maxLength   int
+	singleLine  bool
+	charset     *regexp.Regexp
+	charsetName string
+	hosts       []string
+}
+
+func compileValueRules(rules []utils.ValueRule) ([]*valueRule, error) {
+	var out []*valueRule
+	for i, r := range rules {
+		names, err := compilePatterns([]string{r.Name})
+		if err != nil {
+			return nil, fmt.Errorf("值规则 %d: %w", i+1, err)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("值规则 %d 未配置 name", i+1)
+		}
+		vr := &valueRule{name: names[0], maxLength: r.MaxLength, singleLine: r.SingleLine, charsetName: r.Charset, hosts: r.Hosts}
+
+		for _, t := range r.Transform {
+			t = strings.TrimSpace(t)
+			switch {
+			case t == "trim", t == "lower", t == "upper":
+			case strings.HasPrefix(t, "query:") && len(t) > len("query:"):
+			default:
+				return nil, fmt.Errorf("值规则 %d 未知的转换: %s", i+1, t)
+			}
+			vr.transform = append(vr.transform, t)
+		}
+		if r.Pattern != "" {
+			if vr.pattern, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, fmt.Errorf("值规则 %d 正则无效: %w", i+1, err)
+			}
+		}
+		if r.Charset != "" {
+			class, ok := charsets[strings.ToLower(r.Charset)]
+			if !ok {
+				class = r.Charset
+			}
+			if vr.charset, err = regexp.Compile(`^` + class + `*$`); err != nil {
+				return nil, fmt.Errorf("值规则 %d 字符类 %s 无效: %w", i+1, r.Charset, err)
+			}
+		}
+		for _, h := range r.Hosts {
+			if _, err := path.Match(h, ""); err != nil {
+				return nil, fmt.Errorf("值规则 %d 主机通配符 %s 无效: %w", i+1, h, err)
+			}
+		}
+		out = append(out, vr)
+	}
+	return out, nil
+}
+
+// apply 先执行转换再校验，返回处理后的值或拒绝原因
+func (r *valueRule) apply(value string) (string, error) {
+	for _, t := range r.transform {
+		switch {
+		case t == "trim":
+			value = strings.TrimSpace(value)
+		case t == "lower":
+			value = strings.ToLower(value)
+		case t == "upper":
+			value = strings.ToUpper(value)
+		case strings.HasPrefix(t, "query:"):
+			param := strings.TrimPrefix(t, "query:")
+			u, err := url.Parse(strings.TrimSpace(value))
+			if err != nil || u.Host == "" {
+				return value, fmt.Errorf("不是有效链接，无法提取参数 %s", param)
+			}
+			v := u.Query().Get(param)
+			if v == "" {
+				return value, fmt.Errorf("链接中没有参数 %s", param)
+			}
+			value = v
+		}
+	}
+
+	if r.maxLength > 0 {
+		if n := utf8.RuneCountInString(strings.TrimSpace(value)); n > r.maxLength {
+			return value, fmt.Errorf("长度 %d 超过上限 %d", n, r.maxLength)
+		}
+	}
+	if r.singleLine {
+		for _, c := range strings.TrimSpace(value) {
+			if unicode.IsControl(c) {
+				return value, fmt.Errorf("包含控制字符 %U", c)
+			}
+		}
+	}
+	if r.charset != nil && !r.charset.MatchString(value) {
+		return value, fmt.Errorf("包含字符类 %s 之外的字符", r.charsetName)
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return value, fmt.Errorf("不匹配正则 %s", r.pattern)
+	}
+	if len(r.hosts) > 0 {
+		u, err := url.Parse(strings.TrimSpace(value))
+		if err != nil || u.Host == "" {
+			return value, fmt.Errorf("不是有效链接")
+		}
+		if !matchHost(r.hosts, u.Hostname()) {
+			return value, fmt.Errorf("链接主机 %s 不在允许列表中", u.Hostname())
+		}
+	}
+	return value, nil
+}
+
+func matchHost(hosts []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		if ok, _ := path.Match(strings.ToLower(h), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeVars 按第一条匹配变量名的规则转换并校验变量值，返回通过的变量和被拒绝的说明
+// 没有匹配规则的变量原样通过，多行值和换行分隔的列表不受影响
+func sanitizeVars(vars []Var, rules []*valueRule) ([]Var, []string) {
+	var out []Var
+	var rejected []string
+	for _, v := range vars {
+		var err error
+		for _, r := range rules {
+			if r.name.match(v.Name) {
+				v.Value, err = r.apply(v.Value)
+				break
+			}
+		}
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s（%v）", v.Name, err))
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, rejected
+}
@@ -0,0 +1,41 @@
+package watcher
+
+import (
+	"testing"
+
+	"telegram-env-watcher/utils"
+)
+
+func TestSanitizeVars(t *testing.T) {
+	rules, err := compileValueRules([]utils.ValueRule{
+		{Name: "*_activityId", Transform: []string{"trim"}, Charset: "word", MaxLength: 8},
+		{Name: "*_url", SingleLine: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, value string
+		want        string
+		ok          bool
+	}{
+		{"JD_COOKIE", "pt_key=a;\npt_key=b;", "pt_key=a;\npt_key=b;", true}, // 未配置规则的多行值原样通过
+		{"jd_lzkj_activityId", " abc123 ", "abc123", true},
+		{"jd_lzkj_activityId", "abc123456", "", false},
+		{"jd_lzkj_activityId", "abc-12!", "", false},
+		{"jd_shop_url", "https://a.com/\nhttps://b.com/", "", false},
+		{"jd_shop_url", "https://a.com/", "https://a.com/", true},
+	}
+	for _, tt := range tests {
+		out, rejected := sanitizeVars([]Var{{Name: tt.name, Value: tt.value}}, rules)
+		if !tt.ok {
+			if len(out) != 0 || len(rejected) != 1 {
+				t.Errorf("%s=%q: out = %v, want rejected", tt.name, tt.value, out)
+			}
+			continue
+		}
+		if len(out) != 1 || out[0].Value != tt.want {
+			t.Errorf("%s=%q: out = %v, rejected = %v, want %q", tt.name, tt.value, out, rejected, tt.want)
+		}
+	}
+}
@@ -3,9 +3,9 @@ package watcher
 import (
 	"context"
 	"log"
-	"regexp"
 	"strings"
 	"fmt"
+	"time"
 
 	"github.com/gotd/td/tg"
 	"github.com/gotd/td/telegram"
@@ -14,14 +14,173 @@ import (
 	"telegram-env-watcher/utils"
 )
 
-var exportRegexp = regexp.MustCompile(`(?m)^export\s+(\w+)=["']([^"']+)["']`)
+// defaultParser 未配置 format 时使用的变量解析器
+var defaultParser Parser = ShellParser{}
 
 type WatchTargets struct {
 	Channels []tg.InputChannelClass
 	Users   []tg.InputPeerClass
+
+	// 会话 ID 到监听目标设置的映射，用于按来源路由和选择解析方式
+	targets map[int64]*target
+	// 对所有目标生效的全局变量策略
+	global *globalPolicy
+}
+
+// globalPolicy 全局的变量名允许/禁止列表和变量值规则
+type globalPolicy struct {
+	filter *varFilter
+	values []*valueRule
+}
+
+// target 单个监听目标的设置
+type target struct {
+	username string
+	entities EntityMode
+	parser   Parser
+	onDelete ql.DeleteAction
+	filter   *varFilter    // 目标自己的允许/禁止列表
+	global   *globalPolicy // 全局策略，与所有目标共享
+}
+
+func newTarget(cfg utils.ChannelTarget) (*target, error) {
+	mode, err := ParseEntityMode(cfg.Entities)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := compileVarFilter(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	onDelete, err := ql.ParseDeleteAction(cfg.OnDelete)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := ParseFormat(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Rules) > 0 {
+		rp, err := NewRegexParser(cfg.Rules)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(cfg.Format) == "" {
+			parser = rp
+		} else {
+			parser = chainParser{rp, parser}
+		}
+	}
+	if cfg.Decode {
+		if parser, err = newDecodingParser(parser, cfg.DecodeDepth); err != nil {
+			return nil, err
+		}
+	}
+	return &target{username: cfg.Username, entities: mode, parser: normalizedParser{parser}, onDelete: onDelete, filter: filter}, nil
 }
 
-func RegisterHandlers(d *tg.UpdateDispatcher, client *telegram.Client, cfg *utils.Config, targets *WatchTargets) {
+// AddChannel 添加监听的频道/超级群
+func (t *WatchTargets) AddChannel(cfg utils.ChannelTarget, ch tg.InputChannelClass) error {
+	tgt, err := newTarget(cfg)
+	if err != nil {
+		return err
+	}
+	t.Channels = append(t.Channels, ch)
+	if peer, ok := ch.(*tg.InputChannel); ok {
+		t.setTarget(utils.PeerIDFromPeer(&tg.PeerChannel{ChannelID: peer.ChannelID}), tgt)
+	}
+	return nil
+}
+
+// AddUser 添加监听的用户/普通群
+func (t *WatchTargets) AddUser(cfg utils.ChannelTarget, peer tg.InputPeerClass) error {
+	tgt, err := newTarget(cfg)
+	if err != nil {
+		return err
+	}
+	t.Users = append(t.Users, peer)
+	switch v := peer.(type) {
+	case *tg.InputPeerChat:
+		t.setTarget(utils.PeerIDFromPeer(&tg.PeerChat{ChatID: v.ChatID}), tgt)
+	case *tg.InputPeerUser:
+		t.setTarget(utils.PeerIDFromPeer(&tg.PeerUser{UserID: v.UserID}), tgt)
+	case *tg.InputPeerChannel:
+		t.setTarget(utils.PeerIDFromPeer(&tg.PeerChannel{ChannelID: v.ChannelID}), tgt)
+	}
+	return nil
+}
+
+func (t *WatchTargets) setTarget(id int64, tgt *target) {
+	if t.targets == nil {
+		t.targets = make(map[int64]*target)
+	}
+	tgt.global = t.policy()
+	t.targets[id] = tgt
+}
+
+func (t *WatchTargets) policy() *globalPolicy {
+	if t.global == nil {
+		t.global = &globalPolicy{}
+	}
+	return t.global
+}
+
+// SetFilter 设置全局变量名允许/禁止列表，支持通配符和 /正则/
+func (t *WatchTargets) SetFilter(allow, deny []string) error {
+	f, err := compileVarFilter(allow, deny)
+	if err != nil {
+		return err
+	}
+	t.policy().filter = f
+	return nil
+}
+
+// SetValueRules 设置变量值的转换和校验规则
+func (t *WatchTargets) SetValueRules(rules []utils.ValueRule) error {
+	vr, err := compileValueRules(rules)
+	if err != nil {
+		return err
+	}
+	t.policy().values = vr
+	return nil
+}
+
+// lookup 返回会话对应的目标设置，未配置时使用默认设置
+func (t *WatchTargets) lookup(id int64) *target {
+	if tgt, ok := t.targets[id]; ok {
+		return tgt
+	}
+	return &target{entities: EntityAll, parser: normalizedParser{defaultParser}, onDelete: ql.DeleteNone, global: t.policy()}
+}
+
+func RegisterHandlers(d *tg.UpdateDispatcher, client *telegram.Client, router *ql.Router, cfg *utils.Config, targets *WatchTargets) {
+	store := newMessageStore()
+	process := func(ctx context.Context, id int64, tgt *target, peer string, msgs []*tg.Message) error {
+		src := ql.NewSource(id, tgt.username, peer, msgs[0].ID, messagesText(msgs))
+		return handleMessages(ctx, client, router, cfg, store, tgt, msgs, src)
+	}
+
+	// 开启聚合时，相册和同一发送者的连续消息在窗口结束后合并处理，只触发一次脚本
+	var agg *aggregator
+	if cfg.Aggregate.Window > 0 {
+		agg = newAggregator(time.Duration(cfg.Aggregate.Window)*time.Second, func(ctx context.Context, b *batch) {
+			if len(b.msgs) > 1 {
+				log.Printf("🧩 合并 [%s] 的 %d 条消息一起处理", b.peer, len(b.msgs))
+			}
+			if err := process(ctx, b.id, b.tgt, b.peer, b.msgs); err != nil {
+				log.Printf("❌ 处理消息失败: %v", err)
+			}
+		})
+	}
+	dispatch := func(ctx context.Context, id int64, peer string, msg *tg.Message) error {
+		tgt := targets.lookup(id)
+		if agg != nil {
+			agg.add(ctx, id, tgt, peer, msg)
+			return nil
+		}
+		return process(ctx, id, tgt, peer, []*tg.Message{msg})
+	}
+
 	d.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
 		msg, ok := update.Message.(*tg.Message)
 		if !ok || msg == nil {
@@ -32,11 +191,12 @@ func RegisterHandlers(d *tg.UpdateDispatcher, client *telegram.Client, cfg *util
 		if !containsChannel(targets.Channels, id) {
 			return nil
 		}
+		peer := resolvePeerName(msg.PeerID, e)
 		log.Printf("📢 来自频道 [%s] by [%s]\n内容: %s\n",
-			resolvePeerName(msg.PeerID, e),
+			peer,
 			resolveSenderName(msg.FromID, e),
 			msg.Message)
-		return handleMessage(ctx, client, cfg, msg)
+		return dispatch(ctx, id, peer, msg)
 	})
 
 	//监听普通群（旧版TG，现在新版都是超级群，走的是Channel）
@@ -50,11 +210,60 @@ func RegisterHandlers(d *tg.UpdateDispatcher, client *telegram.Client, cfg *util
 		if !containsUser(targets.Users, id) {
 			return nil
 		}
+		peer := resolvePeerName(msg.PeerID, e)
 		log.Printf("💬 来自群组 [%s] by [%s]\n内容: %s\n",
-			resolvePeerName(msg.PeerID, e),
+			peer,
 			resolveSenderName(msg.FromID, e),
 			msg.Message)
-		return handleMessage(ctx, client, cfg, msg)
+		return dispatch(ctx, id, peer, msg)
+	})
+
+	// 编辑过的消息：比较编辑前后的变量，只写入有变化的部分
+	edit := func(ctx context.Context, id int64, msg *tg.Message) error {
+		if agg != nil && agg.replace(id, msg) {
+			log.Printf("✏️ 消息 #%d 在聚合窗口内被编辑，使用编辑后的内容", msg.ID)
+			return nil
+		}
+		return handleEdit(ctx, client, router, cfg, store, id, msg)
+	}
+
+	d.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
+		msg, ok := update.Message.(*tg.Message)
+		if !ok || msg == nil {
+			return nil
+		}
+		id := utils.PeerIDFromPeer(msg.PeerID)
+		if !containsChannel(targets.Channels, id) {
+			return nil
+		}
+		log.Printf("✏️ 频道 [%s] 编辑了消息 #%d\n内容: %s\n", resolvePeerName(msg.PeerID, e), msg.ID, msg.Message)
+		return edit(ctx, id, msg)
+	})
+
+	d.OnEditMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditMessage) error {
+		msg, ok := update.Message.(*tg.Message)
+		if !ok || msg == nil {
+			return nil
+		}
+		id := utils.PeerIDFromPeer(msg.PeerID)
+		if !containsUser(targets.Users, id) {
+			return nil
+		}
+		log.Printf("✏️ 群组 [%s] 编辑了消息 #%d\n内容: %s\n", resolvePeerName(msg.PeerID, e), msg.ID, msg.Message)
+		return edit(ctx, id, msg)
+	})
+
+	// 频道删除消息后按目标设置撤销其写入的变量；普通群的删除事件不带会话信息，无法对应
+	d.OnDeleteChannelMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteChannelMessages) error {
+		id := utils.PeerIDFromPeer(&tg.PeerChannel{ChannelID: update.ChannelID})
+		if !containsChannel(targets.Channels, id) && !containsUser(targets.Users, id) {
+			return nil
+		}
+		tgt := targets.lookup(id)
+		if tgt.onDelete == ql.DeleteNone {
+			return nil
+		}
+		return handleDelete(ctx, router, store, tgt, id, update.Messages)
 	})
 }
 
@@ -91,92 +300,255 @@ func containsUser(users []tg.InputPeerClass, id int64) bool {
 	return false
 }
 
-func handleMessage(ctx context.Context, client *telegram.Client, cfg *utils.Config, msg *tg.Message) error {
-	if msg == nil || msg.Message == "" {
+// handleMessages 处理一条消息或聚合后的一组消息：解析变量、写入青龙并触发脚本
+func handleMessages(ctx context.Context, client *telegram.Client, router *ql.Router, cfg *utils.Config, store *messageStore, tgt *target, msgs []*tg.Message, src ql.Source) error {
+	var valid []*tg.Message
+	for _, msg := range msgs {
+		if msg != nil && (msg.Message != "" || msg.Media != nil) {
+			valid = append(valid, msg)
+		}
+	}
+	if len(valid) == 0 {
 		return nil
 	}
 
-	matches := exportRegexp.FindAllStringSubmatch(msg.Message, -1)
-	if len(matches) == 0 {
-		log.Println("❌ 消息中未匹配到任何 export 变量")
+	vars, errs := extractVars(ctx, client, cfg, tgt, valid)
+	// 记录解析结果，消息被编辑时据此比较变量变化
+	store.put(src.PeerID, tgt, src.Peer, valid, vars)
+	if len(vars) == 0 {
+		log.Println("❌ 消息中未匹配到任何变量")
+		if len(errs) > 0 {
+			rep := newReport(false)
+			rep.errs = errs
+			ql.SendNotifyViaQL("📥 青龙处理结果通知", rep.String())
+		}
 		return nil
 	}
 
-	var updatedVars []string
-	var runScripts []string
-	var notifyErrs []string
+	rep := newReport(len(router.Clients()) > 1)
+	rep.errs = append(rep.errs, errs...)
+	return applyVars(ctx, router, tgt, rep, vars, src)
+}
 
-	for _, match := range matches {
-		key := strings.TrimSpace(match[1])
-		value := strings.TrimSpace(match[2])
+// applyVars 把变量写入路由到的青龙实例，触发受影响的脚本并发送通知
+// 写入前先按全局和目标的允许/禁止列表过滤，再按值规则转换和校验，被拦截的变量列在通知中
+func applyVars(ctx context.Context, router *ql.Router, tgt *target, rep *report, vars []Var, src ql.Source) error {
+	vars, rejected := filterVars(vars, tgt.global.filter, tgt.filter)
+	vars, invalid := sanitizeVars(vars, tgt.global.values)
+	for _, r := range rejected {
+		log.Printf("🚫 拦截变量: %s", r)
+	}
+	for _, r := range invalid {
+		log.Printf("🚫 变量值不合法: %s", r)
+	}
+	rep.rejected = rejected
+	rep.invalid = invalid
+
+	// 第一阶段：先写入全部变量，记录每个实例受影响的脚本前缀
+	var touched []*ql.Client
+	prefixes := make(map[*ql.Client][]string)
+	for _, v := range vars {
+		key := v.Name
+		value := strings.TrimSpace(v.Value)
 		log.Printf("🔍 检测到变量: %s = %s\n", key, value)
 
-		if err := ql.UpdateQLEnv(cfg, key, value); err != nil {
-			errMsg := fmt.Sprintf("❌ 更新 %s 失败: %v", key, err)
-			log.Println(errMsg)
-			notifyErrs = append(notifyErrs, errMsg)
-			continue
-		}
+		for _, qlc := range router.Route(key, src) {
+			ir := rep.instance(qlc.Name())
 
-		log.Printf("✅ 青龙环境变量 %s 更新成功", key)
-		updatedVars = append(updatedVars, fmt.Sprintf("%s = %s", key, value))
+			// 别名展开后的名称同样要经过允许/禁止列表，避免借别名写入被禁止的变量
+			var names []string
+			for _, n := range qlc.EnvNames(key) {
+				if reason := checkName(n, tgt.global.filter, tgt.filter); reason != "" {
+					r := fmt.Sprintf("%s → %s（%s）", key, n, reason)
+					log.Printf("🚫 [%s] 拦截别名: %s", qlc.Name(), r)
+					if !containsString(rep.rejected, r) {
+						rep.rejected = append(rep.rejected, r)
+					}
+					continue
+				}
+				names = append(names, n)
+			}
+			if len(names) == 0 {
+				continue
+			}
 
-		prefix := utils.ExtractPrefix(key)
-		log.Printf("🔍 提取的前缀: %s", prefix)
+			results, err := qlc.UpdateEnvNames(ctx, names, value, src)
+			if err != nil {
+				errMsg := fmt.Sprintf("❌ 更新 %s 失败: %v", key, err)
+				log.Printf("[%s] %s", qlc.Name(), errMsg)
+				ir.errs = append(ir.errs, errMsg)
+				continue
+			}
 
-		scripts, err := ql.SearchCrons(cfg, prefix)
-		if err != nil {
-			log.Printf("⚠️ 搜索脚本失败 (前缀: %s): %v", prefix, err)
-			notifyErrs = append(notifyErrs, fmt.Sprintf("搜索脚本失败（%s）: %v", prefix, err))
-			continue
+			log.Printf("✅ [%s] 青龙环境变量 %s 更新成功", qlc.Name(), key)
+			ir.updatedVars = append(ir.updatedVars, fmt.Sprintf("%s = %s", key, value))
+			for _, r := range results {
+				ir.updatedVars = append(ir.updatedVars, "  "+r.String())
+			}
+
+			// 按实际写入的名称（含别名）提取前缀，别名分支的脚本同样会被触发
+			if _, ok := prefixes[qlc]; !ok {
+				touched = append(touched, qlc)
+			}
+			for _, r := range results {
+				prefix := utils.ExtractPrefix(r.Name)
+				if !containsString(prefixes[qlc], prefix) {
+					prefixes[qlc] = append(prefixes[qlc], prefix)
+				}
+			}
+		}
+	}
+
+	// 第二阶段：汇总受影响的脚本，去重后每个脚本只运行一次
+	for _, qlc := range touched {
+		ir := rep.instance(qlc.Name())
+		var scripts []ql.ScriptInfo
+		seen := make(map[int]bool)
+		for _, prefix := range prefixes[qlc] {
+			log.Printf("🔍 提取的前缀: %s", prefix)
+			found, err := qlc.SearchCrons(ctx, prefix)
+			if err != nil {
+				log.Printf("⚠️ [%s] 搜索脚本失败 (前缀: %s): %v", qlc.Name(), prefix, err)
+				ir.errs = append(ir.errs, fmt.Sprintf("搜索脚本失败（%s）: %v", prefix, err))
+				continue
+			}
+			if len(found) == 0 {
+				log.Printf("⚠️ [%s] 未找到任何匹配脚本（前缀: %s）", qlc.Name(), prefix)
+				continue
+			}
+			for _, s := range found {
+				if !seen[s.ID] {
+					seen[s.ID] = true
+					scripts = append(scripts, s)
+				}
+			}
 		}
 
 		if len(scripts) == 0 {
-			log.Printf("⚠️ 未找到任何匹配脚本（前缀: %s）", prefix)
 			continue
 		}
 
-		log.Printf("📜 找到 %d 个匹配脚本", len(scripts))
-		for _, s := range scripts {
-			runScripts = append(runScripts, fmt.Sprintf("%s (ID: %d)", s.Name, s.ID))
+		log.Printf("📜 [%s] 找到 %d 个匹配脚本", qlc.Name(), len(scripts))
+
+		res, err := qlc.RunCrons(ctx, scripts)
+		restarted := make(map[int]bool)
+		for _, s := range res.Restarted {
+			restarted[s.ID] = true
+		}
+		for _, s := range res.Started {
+			line := fmt.Sprintf("%s (ID: %d)", s.Name, s.ID)
+			if restarted[s.ID] {
+				line += " 🔁 已重启"
+			}
+			ir.runScripts = append(ir.runScripts, line)
+		}
+		for _, s := range res.Queued {
+			ir.runScripts = append(ir.runScripts, fmt.Sprintf("%s (ID: %d) ⏳ 运行结束后触发", s.Name, s.ID))
+		}
+		for _, s := range res.Suppressed {
+			ir.suppressed = append(ir.suppressed, s.String())
 		}
+		if err != nil {
+			log.Printf("❌ [%s] 脚本运行失败: %v", qlc.Name(), err)
+			ir.errs = append(ir.errs, fmt.Sprintf("脚本运行失败（前缀: %s）: %v", strings.Join(prefixes[qlc], ", "), err))
+		}
+	}
+
+	// ✅ 最终统一发送通知
+	ql.SendNotifyViaQL("📥 青龙处理结果通知", rep.String())
+	return nil
+}
 
-		if err := ql.RunCrons(cfg, scripts); err != nil {
-			log.Printf("❌ 脚本运行失败: %v", err)
-			notifyErrs = append(notifyErrs, fmt.Sprintf("脚本运行失败（前缀: %s）: %v", prefix, err))
+// extractVars 从消息正文、文本附件和图片二维码中解析变量，并把其中的链接交给 URL 规则，
+// 多条消息的正文合并后只解析一次，返回变量和需要通知的错误
+func extractVars(ctx context.Context, client *telegram.Client, cfg *utils.Config, tgt *target, msgs []*tg.Message) ([]Var, []string) {
+	var errs []string
+	var vars []Var
+	if cfg.Debug {
+		for _, msg := range msgs {
+			if msg.Message != "" {
+				logNormalized("消息", msg.Message)
+			}
 		}
 	}
+	vs, perrs := parseEntities(tgt.parser, msgs, tgt.entities)
+	vars = append(vars, vs...)
+	for _, e := range perrs {
+		log.Printf("⚠️ 解析失败: %s", e)
+		errs = append(errs, "解析失败: "+e.String())
+	}
 
-	// 📣 构造最终通知消息
-	var notifyMsg string
-	if len(updatedVars) > 0 {
-		notifyMsg += "✅ 已更新以下环境变量:\n"
-		for _, kv := range updatedVars {
-			notifyMsg += "- " + kv + "\n"
+	parseExtra := func(label, text string) {
+		if cfg.Debug {
+			logNormalized(label, text)
+		}
+		vs, perrs := tgt.parser.Parse(text)
+		vars = append(vars, vs...)
+		for _, e := range perrs {
+			log.Printf("⚠️ 解析%s失败: %s", label, e)
+			errs = append(errs, fmt.Sprintf("解析%s失败: %s", label, e))
 		}
 	}
 
-	if len(runScripts) > 0 {
-		notifyMsg += "\n🚀 已执行以下脚本:\n"
-		for _, name := range runScripts {
-			notifyMsg += "- " + name + "\n"
+	var urls []string
+	for _, msg := range msgs {
+		urls = append(urls, messageURLs(msg)...)
+		if name, text, err := documentText(ctx, client, cfg, msg); err != nil {
+			log.Printf("❌ 下载附件 %s 失败: %v", name, err)
+			errs = append(errs, fmt.Sprintf("附件 %s 读取失败: %v", name, err))
+		} else if text != "" {
+			parseExtra("附件 "+name, text)
+			urls = append(urls, textURLs(text)...)
+		}
+
+		texts, err := photoQRCodes(ctx, client, cfg, msg)
+		if err != nil {
+			log.Printf("❌ 识别图片二维码失败: %v", err)
+			errs = append(errs, fmt.Sprintf("图片二维码识别失败: %v", err))
+		}
+		for _, text := range texts {
+			log.Printf("🔳 识别到二维码: %s", text)
+			parseExtra("二维码", text)
+			urls = append(urls, textURLs(text)...)
 		}
 	}
 
-	if len(notifyErrs) > 0 {
-		notifyMsg += "\n❗发生以下错误:\n"
-		for _, err := range notifyErrs {
-			notifyMsg += "- " + err + "\n"
+	vars = append(vars, urlVars(cfg.URLRules, urls)...)
+	return uniqueVars(vars), errs
+}
+
+// messagesText 合并多条消息的正文，用于记录来源
+func messagesText(msgs []*tg.Message) string {
+	var texts []string
+	for _, msg := range msgs {
+		if msg.Message != "" {
+			texts = append(texts, msg.Message)
 		}
 	}
+	return strings.Join(texts, "\n")
+}
 
-	if notifyMsg == "" {
-		notifyMsg = "⚠️ 未检测到变量或脚本更新"
+// uniqueVars 去掉名称和值都相同的重复变量，如按钮链接与正文中的同一链接
+func uniqueVars(vars []Var) []Var {
+	seen := make(map[Var]bool)
+	out := vars[:0]
+	for _, v := range vars {
+		key := Var{Name: v.Name, Value: v.Value}
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, v)
+		}
 	}
+	return out
+}
 
-	// ✅ 最终统一发送通知
-	ql.SendNotifyViaQL(cfg, "📥 青龙处理结果通知", notifyMsg)
-	return nil
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func resolvePeerName(peer tg.PeerClass, entities tg.Entities) string {